@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/pzl/wapb/internal/server"
+	"github.com/sirupsen/logrus"
+)
+
+// runCommand handles the non-server modes of operation, given as positional args
+func runCommand(args []string, db *badger.DB, log *logrus.Logger) error {
+	switch args[0] {
+	case "export":
+		return exportCommand(args[1:], db, log)
+	case "import":
+		return importCommand(args[1:], db, log)
+	case "reindex":
+		return reindexCommand(db, log)
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// wapb-server export [file]
+// writes to stdout when no file, or "-", is given. A .tar file gets file
+// contents as sidecar files, otherwise they are inlined in the NDJSON
+func exportCommand(args []string, db *badger.DB, log *logrus.Logger) error {
+	var w io.Writer = os.Stdout
+	sidecar := false
+	if len(args) > 0 && args[0] != "-" {
+		f, err := os.Create(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+		sidecar = strings.HasSuffix(args[0], ".tar")
+	}
+
+	if err := server.Export(db, w, sidecar); err != nil {
+		return err
+	}
+	log.Info("export complete")
+	return nil
+}
+
+// wapb-server import [file]
+// reads from stdin when no file, or "-", is given. Format is detected
+func importCommand(args []string, db *badger.DB, log *logrus.Logger) error {
+	var r io.Reader = os.Stdin
+	if len(args) > 0 && args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	n, err := server.Import(db, r)
+	log.WithField("imported", n).Info("import finished")
+	if err != nil {
+		return err
+	}
+	return reindexCommand(db, log)
+}
+
+// wapb-server reindex
+// rebuilds the search index from scratch
+func reindexCommand(db *badger.DB, log *logrus.Logger) error {
+	n, err := server.RebuildIndex(db)
+	if err != nil {
+		return err
+	}
+	log.WithField("indexed", n).Info("search index rebuilt")
+	return nil
+}
@@ -2,35 +2,83 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/pzl/wapb/internal/server"
+	"github.com/pzl/wapb/pkg/wapb"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
 )
 
 type Config struct {
 	Port    int
+	Listen  []string // addresses to listen on instead of Port
 	DBPath  string
 	Handler server.StaticHandler
+	Args    []string // subcommand, if any. Runs the server when empty
+	Trash   time.Duration
+	Tokens  []server.Token
+	CORS    []string
+	Inline  []string // content types of files shown in the browser
+	Upload  int64    // most bytes one request may upload. 0 for no limit
+
+	DefaultTTL time.Duration // given to items created without a TTL
+	MaxTTL     time.Duration // longest TTL items may be created with
+
+	EncryptionKey []byte // for encryption at rest. nil for none
+
+	TLS          *tls.Config // nil serves plain HTTP
+	RedirectPort int         // plain HTTP port redirecting to HTTPS. 0 for none
+
+	BaseURL        *url.URL     // public URL generated links start with. nil to take it from requests
+	TrustedProxies []*net.IPNet // proxies allowed to set X-Forwarded-* headers
 }
 
 func setup() (Config, context.Context, context.CancelFunc, *logrus.Logger) {
 	// CLI arg handling
+	configFile := pflag.StringP("config", "c", "", "read settings from this YAML or TOML file. Keys are flag names. Flags and WAPB_* environment variables take precedence")
 	verbose := pflag.CountP("verbose", "v", "increased logging. Use multiple times for more info")
 	j := pflag.BoolP("json", "j", false, "output logs in JSON format")
 	port := pflag.IntP("port", "p", 7473, "Listening port")
+	listen := pflag.StringArray("listen", nil, "address to listen on instead of --port: host:port, unix:/path/to.sock, systemd for socket activation, or systemd:name for one named socket. Repeatable")
 	dev := pflag.BoolP("dev", "d", false, "enable development mode. Listens to npm dev server for static assets")
 	dbpath := pflag.StringP("storage", "s", "wapd", "path to database directory")
 	pflag.Lookup("storage").NoOptDefVal = ":MEMORY:"
+	trash := pflag.Duration("trash-retention", 7*24*time.Hour, "how long deleted items can be restored from the trash. 0 deletes immediately")
+	tokenDefs := pflag.StringArray("token", nil, "enable auth, allowing an API token given as name:secret:scopes. Scopes are read, create, delete, admin. Repeatable")
+	authFile := pflag.String("auth-file", "", "enable auth, allowing the API tokens in this file. One name:secret:scopes per line")
+	cors := pflag.StringSlice("cors-origin", []string{"*"}, "origins allowed to make cross-origin requests. * for any")
+	inline := pflag.StringSlice("inline-type", server.DefaultInlineTypes, "content types of files shown in the browser, like image/png or video/*. Others are always downloaded")
+	maxUpload := pflag.String("max-upload", "512M", "most one request may upload, in bytes or with a K, M or G suffix. 0 for no limit")
+	defaultTTL := pflag.String("default-ttl", "", "TTL of items created without one, like 30m, 12h or 7d. Unset never expires")
+	maxTTL := pflag.String("max-ttl", "", "longest TTL items may be created with, like 7d. Also the default TTL, when that is unset")
+	tlsCert := pflag.String("tls-cert", "", "serve HTTPS with the certificate in this PEM file. Needs --tls-key")
+	tlsKey := pflag.String("tls-key", "", "private key for --tls-cert, as PEM")
+	tlsSelfSigned := pflag.Bool("tls-self-signed", false, "serve HTTPS with a self-signed certificate, kept in --tls-dir along with the CA that signed it")
+	tlsDir := pflag.String("tls-dir", "wapb-tls", "where self-signed certificates are kept")
+	tlsHosts := pflag.StringSlice("tls-host", nil, "host names and addresses for the self-signed certificate. Defaults to this machine's hostname and addresses")
+	redirectPort := pflag.Int("redirect-port", 0, "with TLS, also listen for plain HTTP on this port, redirecting to HTTPS")
+	baseURL := pflag.String("base-url", "", "public URL the server is reached at, like https://example.com/wapb/, for generated links. The server is mounted under its path. May be only a path, taking the host from each request")
+	proxies := pflag.StringSlice("trusted-proxy", nil, "IPs or CIDR ranges of reverse proxies whose X-Forwarded-For, -Proto and -Host headers are believed, or unix for unix socket connections")
+	keyFile := pflag.String("encryption-key-file", "", "encrypt storage at rest with the AES key in this file. 16, 24 or 32 bytes, raw or hex")
 
 	pflag.Parse()
+	if !pflag.Lookup("config").Changed {
+		*configFile = os.Getenv(envName("config"))
+	}
+	settingsErr := mergeSettings(pflag.CommandLine, *configFile)
 	if port == nil || *port < 1 {
 		*port = 7473
 	}
@@ -42,7 +90,53 @@ func setup() (Config, context.Context, context.CancelFunc, *logrus.Logger) {
 	log := logrus.New()
 	setLogLevel(log, *verbose)
 	setLogMode(log, *j)
+	if settingsErr != nil {
+		log.WithError(settingsErr).Fatal("invalid configuration")
+	}
+	// printing the configuration must not act on it, like creating certs
+	if args := pflag.Args(); len(args) > 0 && args[0] == "config" {
+		if err := configCommand(args[1:], pflag.CommandLine, os.Stdout); err != nil {
+			log.WithError(err).Fatal("command failed")
+		}
+		os.Exit(0)
+	}
 	ah := setAssetHandler(*dev, log)
+	tokens, err := loadTokens(*tokenDefs, *authFile)
+	if err != nil {
+		log.WithError(err).Fatal("invalid auth configuration")
+	}
+	if len(tokens) > 0 {
+		log.WithField("tokens", len(tokens)).Info("authentication enabled")
+	}
+	defTTL, max, err := parseTTLPolicy(*defaultTTL, *maxTTL)
+	if err != nil {
+		log.WithError(err).Fatal("invalid TTL configuration")
+	}
+	upload, err := parseSize(*maxUpload)
+	if err != nil {
+		log.WithError(err).Fatal("invalid upload limit")
+	}
+	if len(*tlsHosts) == 0 {
+		*tlsHosts = defaultTLSHosts()
+	}
+	tlsConfig, err := loadTLS(*tlsCert, *tlsKey, *tlsSelfSigned, *tlsDir, *tlsHosts, log)
+	if err != nil {
+		log.WithError(err).Fatal("invalid TLS configuration")
+	}
+	var base *url.URL
+	if *baseURL != "" {
+		if base, err = server.ParseBaseURL(*baseURL); err != nil {
+			log.WithError(err).Fatal("invalid base URL")
+		}
+	}
+	trusted, err := server.ParseTrustedProxies(*proxies)
+	if err != nil {
+		log.WithError(err).Fatal("invalid trusted proxies")
+	}
+	key, err := loadEncryptionKey(*keyFile)
+	if err != nil {
+		log.WithError(err).Fatal("invalid encryption key")
+	}
 
 	// signal handling & shutdown
 	ctx, cancel := context.WithCancel(context.Background())
@@ -57,12 +151,82 @@ func setup() (Config, context.Context, context.CancelFunc, *logrus.Logger) {
 	// done
 	return Config{
 		Port:    *port,
+		Listen:  *listen,
 		Handler: ah,
 		DBPath:  *dbpath,
+		Args:    pflag.Args(),
+		Trash:   *trash,
+		Tokens:  tokens,
+		CORS:    *cors,
+		Inline:  *inline,
+		Upload:  upload,
+
+		DefaultTTL: defTTL,
+		MaxTTL:     max,
+
+		EncryptionKey: key,
+
+		TLS:          tlsConfig,
+		RedirectPort: *redirectPort,
+
+		BaseURL:        base,
+		TrustedProxies: trusted,
 	}, ctx, cancel, log
 
 }
 
+func loadTokens(defs []string, file string) ([]server.Token, error) {
+	tokens := make([]server.Token, 0, len(defs))
+	for _, def := range defs {
+		t, err := server.ParseToken(def)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	if file != "" {
+		fromFile, err := server.LoadTokens(file)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, fromFile...)
+	}
+	return tokens, nil
+}
+
+func parseTTLPolicy(defaultTTL string, maxTTL string) (def time.Duration, max time.Duration, err error) {
+	if defaultTTL != "" {
+		if def, err = wapb.ParseTTL(defaultTTL); err != nil {
+			return 0, 0, err
+		}
+	}
+	if maxTTL != "" {
+		if max, err = wapb.ParseTTL(maxTTL); err != nil {
+			return 0, 0, err
+		}
+	}
+	if max > 0 && def > max {
+		return 0, 0, fmt.Errorf("default TTL %s is longer than the max TTL %s", def, max)
+	}
+	return def, max, nil
+}
+
+// reads a size in bytes, or with a K, M or G suffix for KiB, MiB or GiB
+func parseSize(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	unit := int64(1)
+	for suffix, u := range map[string]int64{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30} {
+		if strings.HasSuffix(v, suffix) {
+			unit, v = u, strings.TrimSuffix(v, suffix)
+		}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/unit {
+		return 0, fmt.Errorf("invalid size %q. Use bytes, or a size like 64K, 512M or 2G", s)
+	}
+	return n * unit, nil
+}
+
 type SPAFileSystem struct {
 	http.FileSystem
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// badger's default. New data keys are generated this often, under the master key
+const dataKeyRotation = 10 * 24 * time.Hour
+
+// reads an AES key for encryption at rest. The file may hold the raw 16, 24
+// or 32 bytes, or the same hex encoded. No file means no encryption
+func loadEncryptionKey(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := hex.DecodeString(string(bytes.TrimSpace(buf))); err == nil && validKeySize(len(key)) {
+		return key, nil
+	}
+	if validKeySize(len(buf)) {
+		return buf, nil
+	}
+	return nil, fmt.Errorf("%s: encryption key must be 16, 24 or 32 bytes, raw or hex encoded", path)
+}
+
+func validKeySize(n int) bool { return n == 16 || n == 24 || n == 32 }
+
+// explains failures to open the storage which are down to the encryption key
+func openError(err error, cfg Config) string {
+	switch {
+	case errors.Is(err, badger.ErrEncryptionKeyMismatch) && len(cfg.EncryptionKey) == 0:
+		return "the storage is encrypted. Pass its key with --encryption-key-file"
+	case errors.Is(err, badger.ErrEncryptionKeyMismatch):
+		return "the encryption key does not match the one the storage was encrypted with"
+	case errors.Is(err, badger.ErrInvalidEncryptionKey):
+		return "invalid encryption key"
+	}
+	return "unable to open database"
+}
+
+// wapb-server [--encryption-key-file old] rotate-key [new-key-file]
+// re-encrypts the storage's data keys under a new master key. Without a new
+// key file, encryption is turned off. Existing data is re-encrypted lazily,
+// as badger compacts it. The server must not be running
+func rotateKeyCommand(args []string, cfg Config, log *logrus.Logger) error {
+	if cfg.DBPath == ":MEMORY:" {
+		return errors.New("in-memory storage has no key to rotate")
+	}
+
+	var newKey []byte
+	if len(args) > 0 {
+		var err error
+		if newKey, err = loadEncryptionKey(args[0]); err != nil {
+			return err
+		}
+	}
+
+	// opening first checks the current key, and that nothing else has the storage open
+	db, err := badger.Open(badger.DefaultOptions(cfg.DBPath).WithLogger(log).WithEncryptionKey(cfg.EncryptionKey))
+	if err != nil {
+		return fmt.Errorf("%s: %w", openError(err, cfg), err)
+	}
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	opts := badger.KeyRegistryOptions{
+		Dir:                           cfg.DBPath,
+		ReadOnly:                      true,
+		EncryptionKey:                 cfg.EncryptionKey,
+		EncryptionKeyRotationDuration: dataKeyRotation,
+	}
+	kr, err := badger.OpenKeyRegistry(opts)
+	if err != nil {
+		return err
+	}
+	defer kr.Close()
+
+	opts.EncryptionKey = newKey
+	if err := badger.WriteKeyRegistry(kr, opts); err != nil {
+		return err
+	}
+	log.WithField("encrypted", len(newKey) > 0).Info("encryption key rotated")
+	return nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"net/http"
+	"os"
 
 	badger "github.com/dgraph-io/badger/v2"
 	"github.com/pzl/wapb/internal/server"
@@ -13,21 +14,65 @@ func main() {
 	cfg, ctx, cancel, log := setup()
 	defer cancel()
 
+	// key rotation works on the storage while it is closed
+	if len(cfg.Args) > 0 && cfg.Args[0] == "rotate-key" {
+		if err := rotateKeyCommand(cfg.Args[1:], cfg, log); err != nil {
+			log.WithError(err).Error("command failed")
+			os.Exit(1)
+		}
+		return
+	}
+
 	dbopts := badger.DefaultOptions(cfg.DBPath).WithLogger(log)
 	if cfg.DBPath == ":MEMORY:" {
 		dbopts.InMemory = true
 		dbopts.Dir = ""
 		dbopts.ValueDir = ""
 	}
+	if len(cfg.EncryptionKey) > 0 {
+		// without a block cache, blocks are decrypted on every read
+		dbopts = dbopts.WithEncryptionKey(cfg.EncryptionKey).
+			WithEncryptionKeyRotationDuration(dataKeyRotation).
+			WithBlockCacheSize(64 << 20)
+	}
 
 	db, err := badger.Open(dbopts)
 	if err != nil {
-		log.WithError(err).Error("unable to open database")
-		panic(err)
+		log.WithError(err).Error(openError(err, cfg))
+		os.Exit(1)
 	}
 	defer db.Close()
 
-	srv, err := server.New(log, cfg.Port, cfg.Handler, db)
+	if len(cfg.Args) > 0 {
+		if err := runCommand(cfg.Args, db, log); err != nil {
+			log.WithError(err).Error("command failed")
+			db.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
+	opts := []server.Option{
+		server.WithTrash(cfg.Trash),
+		server.WithTTL(cfg.DefaultTTL, cfg.MaxTTL),
+		server.WithTokens(cfg.Tokens...),
+		server.WithCORS(cfg.CORS...),
+		server.WithInlineTypes(cfg.Inline...),
+		server.WithMaxUpload(cfg.Upload),
+		server.WithListen(cfg.Listen...),
+		server.WithTrustedProxies(cfg.TrustedProxies...),
+	}
+	if cfg.BaseURL != nil {
+		opts = append(opts, server.WithBaseURL(cfg.BaseURL))
+	}
+	if cfg.TLS != nil {
+		opts = append(opts, server.WithTLS(cfg.TLS))
+		if cfg.RedirectPort > 0 {
+			opts = append(opts, server.WithRedirect(cfg.RedirectPort))
+		}
+	}
+
+	srv, err := server.New(log, cfg.Port, cfg.Handler, db, opts...)
 	if err != nil {
 		log.WithError(err).Error("error creating server")
 		panic(err)
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml"
+	"github.com/spf13/pflag"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Every flag can also be set from the environment as WAPB_<FLAG>, like
+// WAPB_DEFAULT_TTL for --default-ttl, or from a YAML or TOML config file
+// whose keys are the flag names. Flags win over the environment, which wins
+// over the file
+
+const envPrefix = "WAPB_"
+
+// the environment variable setting a flag
+func envName(flag string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(flag, "-", "_", -1))
+}
+
+// fills in the flags not given on the command line from the environment,
+// then from the config file, if any
+func mergeSettings(fs *pflag.FlagSet, configFile string) error {
+	values := map[string][]string{}
+	if configFile != "" {
+		fromFile, err := readConfigFile(configFile)
+		if err != nil {
+			return err
+		}
+		for name, v := range fromFile {
+			if fs.Lookup(name) == nil || name == "config" {
+				return fmt.Errorf("%s: unknown setting %q", configFile, name)
+			}
+			values[name] = v
+		}
+	}
+
+	fs.VisitAll(func(f *pflag.Flag) {
+		v, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+		if f.Value.Type() == "stringArray" {
+			// array items may hold commas, like token scopes
+			values[f.Name] = strings.Fields(v)
+		} else {
+			values[f.Name] = []string{v}
+		}
+	})
+
+	var err error
+	fs.VisitAll(func(f *pflag.Flag) {
+		v, ok := values[f.Name]
+		if !ok || f.Changed || err != nil {
+			return
+		}
+		if len(v) == 0 {
+			// an empty list clears the default
+			if s, ok := f.Value.(pflag.SliceValue); ok {
+				err = s.Replace(nil)
+			}
+			return
+		}
+		for _, item := range v {
+			if err = fs.Set(f.Name, item); err != nil {
+				err = fmt.Errorf("%s: %w", f.Name, err)
+				return
+			}
+		}
+	})
+	return err
+}
+
+// reads a config file as a map of flag names to their values. TOML files
+// are told apart by their extension, anything else is read as YAML
+func readConfigFile(path string) (map[string][]string, error) {
+	raw := map[string]interface{}{}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		tree, err := toml.LoadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		raw = tree.ToMap()
+	} else {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(buf, &raw); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	values := make(map[string][]string, len(raw))
+	for key, v := range raw {
+		name := strings.Replace(key, "_", "-", -1)
+		switch v := v.(type) {
+		case []interface{}:
+			values[name] = make([]string, 0, len(v))
+			for _, item := range v {
+				s, ok := scalar(item)
+				if !ok {
+					return nil, fmt.Errorf("%s: %s may only list plain values", path, key)
+				}
+				values[name] = append(values[name], s)
+			}
+		default:
+			s, ok := scalar(v)
+			if !ok {
+				return nil, fmt.Errorf("%s: %s must be a plain value or a list", path, key)
+			}
+			values[name] = []string{s}
+		}
+	}
+	return values, nil
+}
+
+func scalar(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), true
+	case nil:
+		return "", true
+	}
+	return "", false
+}
+
+// wapb-server config print
+// writes the effective settings, after merging flags, environment and config
+// file, as a YAML config file. Token secrets are masked
+func configCommand(args []string, fs *pflag.FlagSet, w io.Writer) error {
+	if len(args) == 0 || args[0] != "print" {
+		return fmt.Errorf("usage: config print")
+	}
+
+	settings := yaml.MapSlice{}
+	fs.VisitAll(func(f *pflag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		var v interface{} = f.Value.String()
+		switch val := f.Value.(type) {
+		case pflag.SliceValue:
+			items := val.GetSlice()
+			if f.Name == "token" {
+				items = maskTokens(items)
+			}
+			v = items
+		default:
+			switch f.Value.Type() {
+			case "bool":
+				v, _ = strconv.ParseBool(f.Value.String())
+			case "int", "count":
+				v, _ = strconv.Atoi(f.Value.String())
+			}
+		}
+		settings = append(settings, yaml.MapItem{Key: f.Name, Value: v})
+	})
+
+	out, err := yaml.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// hides the secret in name:secret:scopes token definitions
+func maskTokens(defs []string) []string {
+	masked := make([]string, len(defs))
+	for i, def := range defs {
+		parts := strings.SplitN(def, ":", 3)
+		if len(parts) == 3 {
+			parts[1] = "********"
+		}
+		masked[i] = strings.Join(parts, ":")
+	}
+	return masked
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	caValidity   = 10 * 365 * 24 * time.Hour
+	certValidity = 397 * 24 * time.Hour // the longest browsers accept
+	certRenewal  = 30 * 24 * time.Hour  // issue a new cert when the old expires within this
+)
+
+// builds the TLS configuration from the given cert and key files, or from a
+// self-signed cert kept in dir. nil when TLS is off
+func loadTLS(certFile string, keyFile string, selfSigned bool, dir string, hosts []string, log *logrus.Logger) (*tls.Config, error) {
+	switch {
+	case certFile != "" || keyFile != "":
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("--tls-cert and --tls-key must be given together")
+		}
+	case selfSigned:
+		var err error
+		if certFile, keyFile, err = selfSignedCert(dir, hosts, log); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// the names a self-signed cert is made for: this machine's hostname, its mDNS
+// name, localhost, and every address it has
+func defaultTLSHosts() []string {
+	hosts := []string{"localhost"}
+	if name, err := os.Hostname(); err == nil && name != "" {
+		hosts = append(hosts, name)
+		if !strings.Contains(name, ".") {
+			hosts = append(hosts, name+".local")
+		}
+	}
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		if ipn, ok := a.(*net.IPNet); ok && !ipn.IP.IsLinkLocalUnicast() {
+			hosts = append(hosts, ipn.IP.String())
+		}
+	}
+	return hosts
+}
+
+// makes sure dir holds a CA, and a server cert it signed for the given hosts.
+// The CA is kept across runs, so clients only need to trust it once. The
+// server cert is issued again when it nears expiry, or the hosts change.
+// Returns the server cert and key paths
+func selfSignedCert(dir string, hosts []string, log *logrus.Logger) (string, string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", err
+	}
+	caCertFile, caKeyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	ca, caKey, err := loadCert(caCertFile, caKeyFile)
+	if os.IsNotExist(err) {
+		ca, caKey, err = issueCert(caCertFile, caKeyFile, nil, nil, nil)
+		if err == nil {
+			log.WithField("ca", caCertFile).Warn("created a certificate authority. Install it on clients to trust this server")
+		}
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("self-signed CA: %w", err)
+	}
+
+	cert, _, err := loadCert(certFile, keyFile)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return "", "", err
+	case time.Until(cert.NotAfter) > certRenewal && coversHosts(cert, hosts) && cert.CheckSignatureFrom(ca) == nil:
+		return certFile, keyFile, nil
+	}
+
+	if _, _, err := issueCert(certFile, keyFile, hosts, ca, caKey); err != nil {
+		return "", "", fmt.Errorf("self-signed cert: %w", err)
+	}
+	log.WithField("hosts", hosts).Info("issued self-signed certificate")
+	return certFile, keyFile, nil
+}
+
+func coversHosts(cert *x509.Certificate, hosts []string) bool {
+	for _, h := range hosts {
+		if cert.VerifyHostname(h) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func loadCert(certFile string, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, nil, fmt.Errorf("%s: no certificate found", certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if block, _ = pem.Decode(keyPEM); block == nil {
+		return nil, nil, fmt.Errorf("%s: no key found", keyFile)
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// creates and saves a cert. Without a parent, it is a CA signing itself
+func issueCert(certFile string, keyFile string, hosts []string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		NotBefore:    now.Add(-time.Hour),
+	}
+	if parent == nil {
+		tmpl.Subject = pkix.Name{Organization: []string{"wapb"}, CommonName: "wapb local CA"}
+		tmpl.NotAfter = now.Add(caValidity)
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		parent, parentKey = tmpl, key
+	} else {
+		tmpl.Subject = pkix.Name{Organization: []string{"wapb"}, CommonName: hosts[0]}
+		tmpl.NotAfter = now.Add(certValidity)
+		tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		for _, h := range hosts {
+			if ip := net.ParseIP(h); ip != nil {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+			} else {
+				tmpl.DNSNames = append(tmpl.DNSNames, h)
+			}
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writePEM(keyFile, "EC PRIVATE KEY", keyDER, 0600); err != nil {
+		return nil, nil, err
+	}
+	if err := writePEM(certFile, "CERTIFICATE", der, 0644); err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+func writePEM(path string, kind string, der []byte, perm os.FileMode) error {
+	return ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), perm)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// clipboard is somewhere copy reads from and paste writes to
+type clipboard interface {
+	Read() (string, error)
+	Write(text string) error
+}
+
+// clipboard tools, by the name they may be chosen with
+var clipboardTools = map[string]toolClipboard{
+	"wayland": {read: []string{"wl-paste", "--no-newline"}, write: []string{"wl-copy"}},
+	"xclip":   {read: []string{"xclip", "-selection", "clipboard", "-o"}, write: []string{"xclip", "-selection", "clipboard"}},
+	"xsel":    {read: []string{"xsel", "--clipboard", "--output"}, write: []string{"xsel", "--clipboard", "--input"}},
+	"pbcopy":  {read: []string{"pbpaste"}, write: []string{"pbcopy"}},
+}
+
+// finds the clipboard to use. Named ones are one of clipboardTools, osc52,
+// or file:path, which keeps the clipboard in a file for tests and scripts.
+// Without a name, the first available of the system's clipboards is used
+func openClipboard(name string) (clipboard, error) {
+	switch {
+	case strings.HasPrefix(name, "file:"):
+		return fileClipboard(strings.TrimPrefix(name, "file:")), nil
+	case name == "osc52":
+		return osc52Clipboard{}, nil
+	case name != "" && name != "auto":
+		tool, ok := clipboardTools[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown clipboard %q. Use wayland, xclip, xsel, pbcopy, osc52 or file:path", name)
+		}
+		if _, err := exec.LookPath(tool.write[0]); err != nil {
+			return nil, fmt.Errorf("%s clipboard: %w", name, err)
+		}
+		return tool, nil
+	}
+
+	var candidates []string
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		candidates = append(candidates, "wayland")
+	}
+	if os.Getenv("DISPLAY") != "" {
+		candidates = append(candidates, "xclip", "xsel")
+	}
+	if runtime.GOOS == "darwin" {
+		candidates = append(candidates, "pbcopy")
+	}
+	for _, c := range candidates {
+		if _, err := exec.LookPath(clipboardTools[c].write[0]); err == nil {
+			return clipboardTools[c], nil
+		}
+	}
+	if term := os.Getenv("TERM"); term != "" && term != "dumb" {
+		return osc52Clipboard{}, nil
+	}
+	return nil, errors.New("no clipboard found. Install wl-clipboard, xclip or xsel, or pick one with --clipboard")
+}
+
+// a clipboard reached through command line tools
+type toolClipboard struct {
+	read  []string
+	write []string
+}
+
+func (t toolClipboard) Read() (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(t.read[0], t.read[1:]...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w %s", t.read[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+func (t toolClipboard) Write(text string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command(t.write[0], t.write[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w %s", t.write[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// sets the clipboard of the terminal itself with an OSC 52 escape sequence,
+// which works over SSH too. Terminals rarely allow reading it back
+type osc52Clipboard struct{}
+
+func (osc52Clipboard) Read() (string, error) {
+	return "", errors.New("the terminal clipboard (osc52) can only be written. Pipe the text to wapb text instead")
+}
+
+func (osc52Clipboard) Write(text string) error {
+	seq := "\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(text)) + "\a"
+	if os.Getenv("TMUX") != "" {
+		// passed through tmux to the terminal around it
+		seq = "\x1bPtmux;" + strings.Replace(seq, "\x1b", "\x1b\x1b", -1) + "\x1b\\"
+	}
+
+	var w io.Writer = os.Stderr
+	if tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0); err == nil {
+		defer tty.Close()
+		w = tty
+	}
+	_, err := io.WriteString(w, seq)
+	return err
+}
+
+// a clipboard kept in a file. Empty until first written
+type fileClipboard string
+
+func (f fileClipboard) Read() (string, error) {
+	buf, err := ioutil.ReadFile(string(f))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return string(buf), err
+}
+
+func (f fileClipboard) Write(text string) error {
+	return ioutil.WriteFile(string(f), []byte(text), 0600)
+}
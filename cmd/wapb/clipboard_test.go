@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pzl/wapb/pkg/wapb"
+)
+
+// a server keeping texts and links in memory, enough for copy and paste
+type fakeServer struct {
+	mu    sync.Mutex
+	next  int64
+	texts map[string]wapb.Text
+	links map[string]wapb.Redirect
+}
+
+func newFakeServer(t *testing.T) (*fakeServer, *wapb.Client) {
+	f := &fakeServer{texts: map[string]wapb.Text{}, links: map[string]wapb.Redirect{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, wapb.New(srv.URL)
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/"), "/")
+	kind := parts[0]
+	if kind != wapb.KindText && kind != wapb.KindLink {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var out interface{}
+	switch {
+	case r.Method == http.MethodPost && len(parts) == 1:
+		f.next++
+		id := strconv.FormatInt(f.next, 10)
+		if kind == wapb.KindText {
+			var t wapb.Text
+			json.NewDecoder(r.Body).Decode(&t)
+			t.ID, t.Created = id, f.next
+			f.texts[t.ID], out = t, t
+		} else {
+			var l wapb.Redirect
+			json.NewDecoder(r.Body).Decode(&l)
+			l.ID, l.Created = id, f.next
+			f.links[l.ID], out = l, l
+		}
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet && len(parts) == 1:
+		var data []interface{}
+		for _, t := range f.texts {
+			data = append(data, t)
+		}
+		if kind == wapb.KindLink {
+			data = nil
+			for _, l := range f.links {
+				data = append(data, l)
+			}
+		}
+		out = map[string]interface{}{"data": data}
+	case r.Method == http.MethodGet && len(parts) == 2:
+		var ok bool
+		if kind == wapb.KindText {
+			out, ok = f.texts[parts[1]]
+		} else {
+			out, ok = f.links[parts[1]]
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+func tempClipboard(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "clipboard")
+	if contents != "" {
+		if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestLooksLikeURL(t *testing.T) {
+	cases := map[string]bool{
+		"https://example.com":                      true,
+		"http://example.com/a?b=c#d":               true,
+		"example.com":                              false,
+		"ftp://example.com":                        false,
+		"https://":                                 false,
+		"https://example.com and more":             false,
+		"https://example.com\nhttps://example.org": false,
+		"just some words":                          false,
+	}
+	for text, want := range cases {
+		if got := looksLikeURL(text); got != want {
+			t.Errorf("looksLikeURL(%q) = %v, want %v", text, got, want)
+		}
+	}
+}
+
+func TestCopy(t *testing.T) {
+	cases := []struct {
+		name      string
+		clipboard string
+		args      []string
+		link      string // stored as a link, or else
+		text      string // stored as a text
+	}{
+		{name: "url", clipboard: " https://example.com/a\n", link: "https://example.com/a"},
+		{name: "text", clipboard: "some notes\n", text: "some notes\n"},
+		{name: "url in text", clipboard: "see https://example.com", text: "see https://example.com"},
+		{name: "url as text", clipboard: "https://example.com/a", args: []string{"--text"}, text: "https://example.com/a"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, c := newFakeServer(t)
+			path := tempClipboard(t, tc.clipboard)
+			if err := runCopy(c, append(tc.args, "--clipboard", "file:"+path)); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(srv.texts)+len(srv.links) != 1 {
+				t.Fatalf("stored %d texts and %d links, want one item", len(srv.texts), len(srv.links))
+			}
+			for _, l := range srv.links {
+				if tc.link == "" {
+					t.Errorf("stored link %q, want text %q", l.URL, tc.text)
+				} else if l.URL != tc.link {
+					t.Errorf("stored link %q, want link %q", l.URL, tc.link)
+				}
+			}
+			for _, tx := range srv.texts {
+				if tc.text == "" {
+					t.Errorf("stored text %q, want link %q", tx.Text, tc.link)
+				} else if tx.Text != tc.text {
+					t.Errorf("stored text %q, want text %q", tx.Text, tc.text)
+				}
+			}
+		})
+	}
+}
+
+func TestCopyEmpty(t *testing.T) {
+	srv, c := newFakeServer(t)
+	path := tempClipboard(t, " \n")
+	if err := runCopy(c, []string{"--clipboard", "file:" + path}); err == nil {
+		t.Error("copied an empty clipboard")
+	}
+	if len(srv.texts)+len(srv.links) != 0 {
+		t.Error("stored an item from an empty clipboard")
+	}
+}
+
+func TestNewestItem(t *testing.T) {
+	_, c := newFakeServer(t)
+	if _, err := newestItem(c); err == nil {
+		t.Error("found an item with none stored")
+	}
+
+	c.CreateText(wapb.Text{Text: "first"})
+	link, _ := c.CreateLink(wapb.Redirect{URL: "https://example.com"})
+	ref, err := newestItem(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Kind != wapb.KindLink || ref.ID != link.ID {
+		t.Errorf("newest is %s %s, want link %s", ref.Kind, ref.ID, link.ID)
+	}
+
+	text, _ := c.CreateText(wapb.Text{Text: "second"})
+	if ref, _ = newestItem(c); ref.Kind != wapb.KindText || ref.ID != text.ID {
+		t.Errorf("newest is %s %s, want text %s", ref.Kind, ref.ID, text.ID)
+	}
+}
+
+func TestPaste(t *testing.T) {
+	_, c := newFakeServer(t)
+	first, _ := c.CreateText(wapb.Text{Text: "first text"})
+	c.CreateLink(wapb.Redirect{URL: "https://example.com/newest"})
+
+	path := tempClipboard(t, "")
+	cb := fileClipboard(path)
+
+	if err := runPaste(c, []string{"--clipboard", "file:" + path}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := cb.Read(); got != "https://example.com/newest" {
+		t.Errorf("pasted %q, want the newest item", got)
+	}
+
+	if err := runPaste(c, []string{"--clipboard", "file:" + path, "text/" + first.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := cb.Read(); got != "first text" {
+		t.Errorf("pasted %q, want the text named", got)
+	}
+
+	if err := runPaste(c, []string{"--clipboard", "file:" + path, "text/missing"}); err == nil {
+		t.Error("pasted a missing item")
+	}
+	if got, _ := cb.Read(); got != "first text" {
+		t.Errorf("clipboard changed to %q by a failed paste", got)
+	}
+}
+
+func TestCopyPasteEncrypted(t *testing.T) {
+	srv, c := newFakeServer(t)
+	path := tempClipboard(t, "a secret")
+	if err := runCopy(c, []string{"--clipboard", "file:" + path, "--encrypt"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tx := range srv.texts {
+		if strings.Contains(tx.Text, "secret") {
+			t.Errorf("stored %q unencrypted", tx.Text)
+		}
+	}
+
+	// the key only lives in the URL, and the newest item has none
+	if err := runPaste(c, []string{"--clipboard", "file:" + path}); err == nil {
+		t.Error("pasted an encrypted item without its key")
+	}
+}
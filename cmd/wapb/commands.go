@@ -0,0 +1,414 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pzl/wapb/pkg/wapb"
+	qrcode "github.com/skip2/go-qrcode"
+	"github.com/spf13/pflag"
+)
+
+// flags shared by every command creating an item
+type createOpts struct {
+	common  wapb.StoredCommon
+	encrypt bool
+	qr      bool
+}
+
+func createFlags(name string) (*pflag.FlagSet, *createOpts) {
+	o := &createOpts{}
+	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	fs.BoolVarP(&o.common.Burn, "burn", "b", false, "delete the item after it is first read")
+	fs.BoolVar(&o.common.Hidden, "hidden", false, "leave the item out of listings")
+	fs.VarP((*ttlValue)(&o.common.TTL), "ttl", "t", "time until the item expires, like 10m, 2h or 7d. Plain numbers are seconds")
+	fs.Var((*expiresValue)(&o.common.Expires), "expires", "when the item expires, as a unix timestamp or RFC 3339 time")
+	fs.BoolVar(&o.common.Sliding, "sliding", false, "renew the TTL each time the item is read")
+	fs.StringArrayVar(&o.common.Tags, "tag", nil, "tag the item, for filtering listings. Repeatable")
+	fs.Int64Var(&o.common.MaxViews, "max-views", 0, "delete the item after this many reads")
+	fs.StringVarP(&o.common.Password, "password", "P", "", "require a password to read the item")
+	fs.BoolVarP(&o.encrypt, "encrypt", "e", false, "encrypt before sending. The key is only kept in the returned URL")
+	fs.BoolVar(&o.qr, "qr", false, "also print a QR code of the URL, to open it on a phone")
+	return fs, o
+}
+
+// a TTL flag, held as seconds
+type ttlValue int64
+
+func (t *ttlValue) Set(s string) error {
+	d, err := wapb.ParseTTL(s)
+	if err != nil {
+		return err
+	}
+	*t = ttlValue(d / time.Second)
+	return nil
+}
+
+func (t *ttlValue) String() string {
+	if *t == 0 {
+		return ""
+	}
+	return (time.Duration(*t) * time.Second).String()
+}
+
+func (t *ttlValue) Type() string { return "duration" }
+
+// an expiration flag, held as a unix timestamp
+type expiresValue int64
+
+func (e *expiresValue) Set(s string) error {
+	t, err := wapb.ParseExpires(s)
+	if err != nil {
+		return err
+	}
+	*e = expiresValue(t.Unix())
+	return nil
+}
+
+func (e *expiresValue) String() string {
+	if *e == 0 {
+		return ""
+	}
+	return time.Unix(int64(*e), 0).Format(time.RFC3339)
+}
+
+func (e *expiresValue) Type() string { return "time" }
+
+// generates a key when encrypting, marking the item as encrypted
+func (o *createOpts) key() (wapb.Key, error) {
+	if !o.encrypt {
+		return nil, nil
+	}
+	o.common.Encrypted = true
+	return wapb.NewKey()
+}
+
+// prints where a new item can be found. The owner token and QR code go to
+// stderr, so the URL alone can be piped along
+func printCreated(c *wapb.Client, kind string, common wapb.StoredCommon, key wapb.Key, qr bool) error {
+	u := c.ItemURL(kind, common.ID, key)
+	fmt.Println(u)
+	if common.Owner != "" {
+		fmt.Fprintln(os.Stderr, "owner token:", common.Owner)
+	}
+	if qr {
+		return printQR(os.Stderr, u, false)
+	}
+	return nil
+}
+
+// draws a QR code with block characters, two rows of modules to a line.
+// Light modules are drawn, for light text on a dark background, unless inverted
+func printQR(w io.Writer, content string, invert bool) error {
+	q, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, q.ToSmallString(invert))
+	return err
+}
+
+func runQR(c *wapb.Client, args []string) error {
+	fs := pflag.NewFlagSet("qr", pflag.ContinueOnError)
+	invert := fs.Bool("invert", false, "draw dark modules instead, for terminals with dark text on a light background")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("expected a single item URL, or type/id")
+	}
+
+	// made here rather than by the server, so encrypted items keep their key
+	return printQR(os.Stdout, itemTarget(c, fs.Arg(0)), *invert)
+}
+
+func runText(c *wapb.Client, args []string) error {
+	fs, opts := createFlags("text")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var text string
+	if fs.NArg() == 0 || (fs.NArg() == 1 && fs.Arg(0) == "-") {
+		buf, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		text = string(buf)
+	} else {
+		text = strings.Join(fs.Args(), " ")
+	}
+	if text == "" {
+		return errors.New("refusing to store empty text")
+	}
+	return createText(c, opts, text)
+}
+
+func createText(c *wapb.Client, opts *createOpts, text string) error {
+	key, err := opts.key()
+	if err != nil {
+		return err
+	}
+	if key != nil {
+		if text, err = key.EncryptString(text); err != nil {
+			return err
+		}
+	}
+
+	t, err := c.CreateText(wapb.Text{StoredCommon: opts.common, Text: text})
+	if err != nil {
+		return err
+	}
+	return printCreated(c, wapb.KindText, t.StoredCommon, key, opts.qr)
+}
+
+func runLink(c *wapb.Client, args []string) error {
+	fs, opts := createFlags("link")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("expected a single URL")
+	}
+
+	return createLink(c, opts, fs.Arg(0))
+}
+
+func createLink(c *wapb.Client, opts *createOpts, link string) error {
+	key, err := opts.key()
+	if err != nil {
+		return err
+	}
+	if key != nil {
+		if link, err = key.EncryptString(link); err != nil {
+			return err
+		}
+	}
+
+	l, err := c.CreateLink(wapb.Redirect{StoredCommon: opts.common, URL: link})
+	if err != nil {
+		return err
+	}
+	return printCreated(c, wapb.KindLink, l.StoredCommon, key, opts.qr)
+}
+
+func runGet(c *wapb.Client, args []string) error {
+	fs := pflag.NewFlagSet("get", pflag.ContinueOnError)
+	password := fs.StringP("password", "P", "", "password of a protected item")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("expected a single item URL, or type/id")
+	}
+
+	ref, err := wapb.ParseItemURL(itemTarget(c, fs.Arg(0)))
+	if err != nil {
+		return err
+	}
+
+	if ref.Kind == wapb.KindFile {
+		ic := refClient(c, ref)
+		f, err := ic.GetFile(ref.ID, *password)
+		if err != nil {
+			return getError(err)
+		}
+		printExpiry(f.StoredCommon)
+		for _, file := range f.Files {
+			fmt.Printf("%s\t%d\t%s\n", file.FileName, file.Size, ic.FileURL(f.ID, file.ID))
+		}
+		return nil
+	}
+
+	common, contents, err := fetchContents(c, ref, *password)
+	if err != nil {
+		return err
+	}
+	printExpiry(common)
+	if ref.Kind == wapb.KindLink {
+		contents += "\n"
+	}
+	fmt.Print(contents)
+	return nil
+}
+
+// a full item URL, from one or from type/id, relative to the configured
+// server and channel
+func itemTarget(c *wapb.Client, target string) string {
+	if parts := strings.SplitN(strings.Trim(target, "/"), "/", 2); !strings.Contains(target, "://") && len(parts) == 2 {
+		return c.ItemURL(parts[0], parts[1], nil)
+	}
+	return target
+}
+
+// a client for the server and channel an item lives on
+func refClient(c *wapb.Client, ref wapb.ItemRef) *wapb.Client {
+	ic := ref.Client()
+	ic.Token = c.Token
+	return ic
+}
+
+// fetches the contents of a text or link, decrypting them with the URL's key
+func fetchContents(c *wapb.Client, ref wapb.ItemRef, password string) (wapb.StoredCommon, string, error) {
+	ic := refClient(c, ref)
+	var common wapb.StoredCommon
+	var contents string
+	switch ref.Kind {
+	case wapb.KindText:
+		t, err := ic.GetText(ref.ID, password)
+		if err != nil {
+			return common, "", getError(err)
+		}
+		common, contents = t.StoredCommon, t.Text
+	case wapb.KindLink:
+		l, err := ic.GetLink(ref.ID, password)
+		if err != nil {
+			return common, "", getError(err)
+		}
+		common, contents = l.StoredCommon, l.URL
+	default:
+		return common, "", fmt.Errorf("%s items have no contents to show", ref.Kind)
+	}
+
+	if common.Encrypted {
+		if ref.Key == nil {
+			return common, "", errors.New("this item is encrypted, and the URL is missing its key")
+		}
+		var err error
+		if contents, err = ref.Key.DecryptString(contents); err != nil {
+			return common, "", err
+		}
+	}
+	return common, contents, nil
+}
+
+// to stderr, keeping stdout to the contents alone
+func printExpiry(common wapb.StoredCommon) {
+	if common.Expires > 0 {
+		fmt.Fprintf(os.Stderr, "expires in %s (%s)\n", time.Duration(common.Remaining)*time.Second, time.Unix(common.Expires, 0).Format(time.RFC1123))
+	}
+}
+
+func getError(err error) error {
+	if err == wapb.ErrPasswordRequired {
+		return errors.New("this item is password protected. Pass it with --password")
+	}
+	return err
+}
+
+func clipboardFlag(fs *pflag.FlagSet) *string {
+	return fs.String("clipboard", os.Getenv("WAPB_CLIPBOARD"), "clipboard to use: wayland, xclip, xsel, pbcopy, osc52, or file:path. Found automatically when unset. Also read from WAPB_CLIPBOARD")
+}
+
+func runCopy(c *wapb.Client, args []string) error {
+	fs, opts := createFlags("copy")
+	asText := fs.Bool("text", false, "store as text, even when it looks like a link")
+	name := clipboardFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("copy takes no arguments. It stores what is in the clipboard")
+	}
+
+	cb, err := openClipboard(*name)
+	if err != nil {
+		return err
+	}
+	text, err := cb.Read()
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(text) == "" {
+		return errors.New("the clipboard is empty")
+	}
+
+	if link := strings.TrimSpace(text); !*asText && looksLikeURL(link) {
+		return createLink(c, opts, link)
+	}
+	return createText(c, opts, text)
+}
+
+// whether text is a single web URL, and nothing else
+func looksLikeURL(text string) bool {
+	if strings.ContainsAny(text, " \t\r\n") {
+		return false
+	}
+	u, err := url.Parse(text)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func runPaste(c *wapb.Client, args []string) error {
+	fs := pflag.NewFlagSet("paste", pflag.ContinueOnError)
+	password := fs.StringP("password", "P", "", "password of a protected item")
+	name := clipboardFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return errors.New("expected at most one item URL, or type/id")
+	}
+
+	var ref wapb.ItemRef
+	var err error
+	if fs.NArg() == 1 {
+		ref, err = wapb.ParseItemURL(itemTarget(c, fs.Arg(0)))
+	} else {
+		ref, err = newestItem(c)
+	}
+	if err != nil {
+		return err
+	}
+
+	// the clipboard is checked first, so a burn after reading item isn't lost
+	cb, err := openClipboard(*name)
+	if err != nil {
+		return err
+	}
+	common, contents, err := fetchContents(c, ref, *password)
+	if err != nil {
+		return err
+	}
+	if err := cb.Write(contents); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "copied %s %s to the clipboard\n", ref.Kind, ref.ID)
+	printExpiry(common)
+	return nil
+}
+
+// the most recently created text or link in the channel
+func newestItem(c *wapb.Client) (wapb.ItemRef, error) {
+	ref := wapb.ItemRef{Server: c.Server, Channel: c.Channel}
+	var newest int64
+
+	texts, err := c.ListTexts()
+	if err != nil {
+		return ref, err
+	}
+	for _, t := range texts {
+		if t.Created >= newest {
+			newest, ref.Kind, ref.ID = t.Created, wapb.KindText, t.ID
+		}
+	}
+	links, err := c.ListLinks()
+	if err != nil {
+		return ref, err
+	}
+	for _, l := range links {
+		if l.Created >= newest {
+			newest, ref.Kind, ref.ID = l.Created, wapb.KindLink, l.ID
+		}
+	}
+
+	if ref.ID == "" {
+		return ref, errors.New("there are no texts or links to paste")
+	}
+	return ref, nil
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pzl/wapb/pkg/wapb"
+)
+
+// uploads are sent in chunks of at most this many bytes, unless a single
+// file is larger
+const chunkBytes = 64 << 20
+
+// a file to upload
+type localFile struct {
+	path string // on disk
+	name string // uploaded as
+	size int64
+}
+
+func runFile(c *wapb.Client, args []string) error {
+	fs, opts := createFlags("file")
+	jobs := fs.IntP("jobs", "j", 4, "upload this many chunks at once")
+	chunk := fs.Int("chunk", 16, "files sent per request")
+	quiet := fs.BoolP("quiet", "q", false, "don't show progress")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("expected files or directories to upload")
+	}
+	if opts.encrypt {
+		return errors.New("files can't be encrypted yet")
+	}
+	if *jobs < 1 || *chunk < 1 {
+		return errors.New("--jobs and --chunk must be at least 1")
+	}
+
+	files, err := expandFiles(fs.Args())
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("no files to upload")
+	}
+
+	group, err := c.CreateFile(wapb.File{StoredCommon: opts.common})
+	if err != nil {
+		return err
+	}
+
+	var progress *uploadProgress
+	if !*quiet && isTerminal(os.Stderr) {
+		progress = newUploadProgress(files)
+	}
+	err = uploadChunks(c, group, chunkFiles(files, *chunk), *jobs, progress)
+	progress.end()
+	if err != nil {
+		return fmt.Errorf("uploading into %s: %w", c.ItemURL(wapb.KindFile, group.ID, nil), err)
+	}
+	return printCreated(c, wapb.KindFile, group.StoredCommon, nil, opts.qr)
+}
+
+// finds the files named by the arguments. Globs are expanded, for shells
+// that didn't, and directories are walked for every file under them
+func expandFiles(args []string) ([]localFile, error) {
+	var files []localFile
+	names := make(map[string]string) // to the path uploaded under that name
+	add := func(root string, path string, size int64) error {
+		name := uploadName(root, path)
+		if other, ok := names[name]; ok {
+			if filepath.Clean(other) == filepath.Clean(path) {
+				return nil // named twice
+			}
+			return fmt.Errorf("%s and %s would both be uploaded as %s", other, path, name)
+		}
+		names[name] = path
+		files = append(files, localFile{path: path, name: name, size: size})
+		return nil
+	}
+
+	for _, arg := range args {
+		matches := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			var err error
+			if matches, err = filepath.Glob(arg); err != nil {
+				return nil, fmt.Errorf("%s: %w", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("%s: no matching files", arg)
+			}
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				if err := add(match, match, info.Size()); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			err = filepath.Walk(match, func(path string, info os.FileInfo, err error) error {
+				if err != nil || !info.Mode().IsRegular() {
+					return err
+				}
+				return add(match, path, info.Size())
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
+
+// the name a file is uploaded as. Files under the working directory keep
+// their relative directories, as in logs/app/today.log. Others are named
+// from the file or directory given, found under root
+func uploadName(root string, path string) string {
+	clean := filepath.Clean(path)
+	if !filepath.IsAbs(clean) && clean != ".." && !strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return filepath.ToSlash(clean)
+	}
+	rel, err := filepath.Rel(filepath.Dir(filepath.Clean(root)), clean)
+	if err != nil {
+		return filepath.Base(clean)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// splits files into the chunks sent per request, by count and size
+func chunkFiles(files []localFile, n int) [][]localFile {
+	var chunks [][]localFile
+	var current []localFile
+	var size int64
+	for _, f := range files {
+		if len(current) == n || (len(current) > 0 && size+f.size > chunkBytes) {
+			chunks = append(chunks, current)
+			current, size = nil, 0
+		}
+		current = append(current, f)
+		size += f.size
+	}
+	if len(current) > 0 {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
+
+// sends chunks into the group, several at once. Stops at the first error
+func uploadChunks(c *wapb.Client, group wapb.File, chunks [][]localFile, jobs int, progress *uploadProgress) error {
+	work := make(chan []localFile)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed error
+
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range work {
+				mu.Lock()
+				skip := failed != nil
+				mu.Unlock()
+				if skip {
+					continue
+				}
+				err := uploadChunk(c, group, chunk, progress)
+				mu.Lock()
+				if err != nil && failed == nil {
+					failed = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, chunk := range chunks {
+		work <- chunk
+	}
+	close(work)
+	wg.Wait()
+	return failed
+}
+
+func uploadChunk(c *wapb.Client, group wapb.File, chunk []localFile, progress *uploadProgress) error {
+	uploads := make([]wapb.Upload, 0, len(chunk))
+	for _, f := range chunk {
+		file, err := os.Open(f.path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		uploads = append(uploads, wapb.Upload{Name: f.name, Body: file})
+	}
+
+	if err := c.Upload(group.ID, group.Owner, uploads, progress.add); err != nil {
+		return err
+	}
+	progress.finish(chunk)
+	return nil
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// progress bars for uploads, redrawn in place: one for each file being sent,
+// and one for the whole set. Methods do nothing on a nil progress
+type uploadProgress struct {
+	mu       sync.Mutex
+	out      io.Writer
+	sizes    map[string]int64 // of every file, by name
+	sent     map[string]int64 // bytes sent of files started
+	active   []string         // files being sent, in the order they started
+	done     int              // files finished
+	total    int64
+	sentAll  int64
+	lines    int // drawn last time, to move back over
+	lastDraw int64
+}
+
+func newUploadProgress(files []localFile) *uploadProgress {
+	p := &uploadProgress{
+		out:   os.Stderr,
+		sizes: make(map[string]int64, len(files)),
+		sent:  make(map[string]int64, len(files)),
+	}
+	for _, f := range files {
+		p.sizes[f.name] = f.size
+		p.total += f.size
+	}
+	return p
+}
+
+// counts bytes sent of a file
+func (p *uploadProgress) add(name string, n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, started := p.sent[name]; !started {
+		p.active = append(p.active, name)
+	}
+	p.sent[name] += int64(n)
+	p.sentAll += int64(n)
+	if p.total == 0 {
+		return
+	}
+	if percent := p.sentAll * 100 / p.total; percent != p.lastDraw {
+		// only redrawn when the total moves, to not flood slow terminals
+		p.lastDraw = percent
+		p.draw()
+	}
+}
+
+// marks a chunk's files done, once the server has them
+func (p *uploadProgress) finish(chunk []localFile) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, f := range chunk {
+		p.done++
+		for i, name := range p.active {
+			if name == f.name {
+				p.active = append(p.active[:i], p.active[i+1:]...)
+				break
+			}
+		}
+	}
+	p.draw()
+}
+
+// clears the file bars, leaving the total
+func (p *uploadProgress) end() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active = nil
+	p.draw()
+}
+
+func (p *uploadProgress) draw() {
+	var b strings.Builder
+	if p.lines > 1 {
+		fmt.Fprintf(&b, "\x1b[%dA", p.lines-1) // back to the first line
+	}
+	b.WriteString("\r")
+	for _, name := range p.active {
+		fmt.Fprintf(&b, "\x1b[K%-32s %s\n", shorten(name, 32), bar(p.sent[name], p.sizes[name]))
+	}
+	fmt.Fprintf(&b, "\x1b[K%-32s %s  %s / %s", fmt.Sprintf("%d/%d files", p.done, len(p.sizes)),
+		bar(p.sentAll, p.total), formatBytes(p.sentAll), formatBytes(p.total))
+	b.WriteString("\x1b[J") // clears bars of files since finished
+	if p.active == nil {
+		b.WriteString("\n")
+	}
+	p.lines = len(p.active) + 1
+	io.WriteString(p.out, b.String())
+}
+
+// like [#########-----------]  45%
+func bar(n int64, total int64) string {
+	const width = 20
+	if total <= 0 {
+		n, total = 1, 1
+	}
+	filled := int(n * width / total)
+	return fmt.Sprintf("[%s%s] %3d%%", strings.Repeat("#", filled), strings.Repeat("-", width-filled), n*100/total)
+}
+
+// keeps the end of long names, where the file name is
+func shorten(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return "…" + string(r[len(r)-n+1:])
+	}
+	return s
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -1,5 +1,84 @@
 package main
 
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/pzl/wapb/pkg/wapb"
+	"github.com/spf13/pflag"
+)
+
+type command struct {
+	usage string
+	run   func(c *wapb.Client, args []string) error
+}
+
+var commands = map[string]command{
+	"text":  {"text [flags] [text...]       store text from the arguments, or stdin", runText},
+	"file":  {"file [flags] path...         upload files into one group. Directories are uploaded whole", runFile},
+	"link":  {"link [flags] url             store a link", runLink},
+	"get":   {"get [flags] url|type/id      fetch an item, decrypting it when the URL carries a key", runGet},
+	"qr":    {"qr [flags] url|type/id       print a QR code of an item's URL", runQR},
+	"copy":  {"copy [flags]                 store the clipboard, as a link when it is a URL", runCopy},
+	"paste": {"paste [flags] [url|type/id]  put an item, or the newest text or link, in the clipboard", runPaste},
+	"watch": {"watch [flags]                follow new items as they are made, like tail -f", runWatch},
+}
+
 func main() {
+	global := pflag.NewFlagSet("wapb", pflag.ContinueOnError)
+	global.SetInterspersed(false) // flags after the command belong to it
+	server := global.StringP("server", "s", env("WAPB_SERVER", "http://localhost:7473"), "server URL. Also read from WAPB_SERVER")
+	token := global.String("token", os.Getenv("WAPB_TOKEN"), "API token, for servers with auth enabled. Also read from WAPB_TOKEN")
+	channel := global.StringP("channel", "c", os.Getenv("WAPB_CHANNEL"), "named channel to use. Also read from WAPB_CHANNEL")
+	global.Usage = func() { usage(global) }
+
+	if err := global.Parse(os.Args[1:]); err != nil {
+		if err == pflag.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(2)
+	}
+	if global.NArg() == 0 {
+		usage(global)
+		os.Exit(2)
+	}
+
+	cmd, exists := commands[global.Arg(0)]
+	if !exists {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", global.Arg(0))
+		usage(global)
+		os.Exit(2)
+	}
+
+	client := wapb.New(*server)
+	client.Token = *token
+	client.Channel = *channel
+
+	if err := cmd.run(client, global.Args()[1:]); err != nil {
+		if err != pflag.ErrHelp {
+			fmt.Fprintln(os.Stderr, "error:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func usage(global *pflag.FlagSet) {
+	fmt.Fprintf(os.Stderr, "usage: wapb [global flags] command [flags] [args]\n\ncommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nglobal flags:\n%s", global.FlagUsages())
+}
 
+func env(key string, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
 }
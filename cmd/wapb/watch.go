@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pzl/wapb/pkg/wapb"
+	"github.com/spf13/pflag"
+)
+
+// follows the items of a channel, showing and acting on new ones
+type watcher struct {
+	c     *wapb.Client
+	seen  map[string]bool // kind/id of items already shown
+	files map[string]bool // IDs of files already shown
+	clip  clipboard       // new texts and links go here, when set
+	open  bool            // open new links in the browser
+	dir   string          // new files are saved here, when set
+}
+
+func runWatch(c *wapb.Client, args []string) error {
+	fs := pflag.NewFlagSet("watch", pflag.ContinueOnError)
+	copyNew := fs.Bool("copy", false, "put each new text or link in the clipboard")
+	name := clipboardFlag(fs)
+	open := fs.Bool("open", false, "open each new link in the browser")
+	dir := fs.String("download", "", "save new files into this directory")
+	poll := fs.Bool("poll", false, "check for new items every --interval, even when the server can stream them")
+	interval := fs.Duration("interval", 5*time.Second, "how often to check for new items, when polling")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("watch takes no arguments")
+	}
+
+	w := &watcher{c: c, open: *open, dir: *dir}
+	if *copyNew {
+		var err error
+		if w.clip, err = openClipboard(*name); err != nil {
+			return err
+		}
+	}
+	if w.dir != "" {
+		if err := os.MkdirAll(w.dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+	}()
+
+	// items from before watching aren't shown
+	if err := w.poll(false); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "watching for new items")
+
+	for ctx.Err() == nil {
+		if *poll {
+			sleep(ctx, *interval)
+		} else if err := w.stream(ctx); err == wapb.ErrNotFound {
+			fmt.Fprintln(os.Stderr, "the server has no event stream. Polling instead")
+			*poll = true
+			continue
+		} else if err != nil && ctx.Err() == nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			sleep(ctx, *interval)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if err := w.poll(true); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+		}
+	}
+	return nil
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+// follows the event stream until it ends. Items made while connecting are
+// caught up on once connected
+func (w *watcher) stream(ctx context.Context) error {
+	events, err := w.c.Events(ctx)
+	if err != nil {
+		return err
+	}
+	defer events.Close()
+	if err := w.poll(true); err != nil {
+		return err
+	}
+
+	for {
+		e, err := events.Next()
+		if err == io.EOF {
+			return nil // dropped by the server. Reconnect
+		}
+		if err != nil {
+			return err
+		}
+		if err := w.event(e); err != nil {
+			return err
+		}
+	}
+}
+
+func (w *watcher) event(e wapb.Event) error {
+	switch e.Kind {
+	case wapb.KindText:
+		var t wapb.Text
+		if err := json.Unmarshal(e.Item, &t); err != nil {
+			return err
+		}
+		w.text(t)
+	case wapb.KindLink:
+		var l wapb.Redirect
+		if err := json.Unmarshal(e.Item, &l); err != nil {
+			return err
+		}
+		w.link(l)
+	case wapb.KindFile:
+		var f wapb.File
+		if err := json.Unmarshal(e.Item, &f); err != nil {
+			return err
+		}
+		w.file(f)
+	}
+	return nil
+}
+
+// lists the channel, showing what wasn't seen yet, oldest first. Forgets
+// items no longer listed, as they are gone for good
+func (w *watcher) poll(show bool) error {
+	texts, err := w.c.ListTexts()
+	if err != nil {
+		return err
+	}
+	links, err := w.c.ListLinks()
+	if err != nil {
+		return err
+	}
+	groups, err := w.c.ListFiles()
+	if err != nil {
+		return err
+	}
+
+	var fresh []func()
+	var created []int64
+	listed := make(map[string]bool, len(texts)+len(links)+len(groups))
+	listedFiles := make(map[string]bool)
+	for _, t := range texts {
+		t := t
+		listed[wapb.KindText+"/"+t.ID] = true
+		fresh, created = append(fresh, func() { w.text(t) }), append(created, t.Created)
+	}
+	for _, l := range links {
+		l := l
+		listed[wapb.KindLink+"/"+l.ID] = true
+		fresh, created = append(fresh, func() { w.link(l) }), append(created, l.Created)
+	}
+	for _, f := range groups {
+		f := f
+		listed[wapb.KindFile+"/"+f.ID] = true
+		for _, file := range f.Files {
+			listedFiles[file.ID] = true
+		}
+		fresh, created = append(fresh, func() { w.file(f) }), append(created, f.Created)
+	}
+
+	if !show {
+		w.seen, w.files = listed, listedFiles
+		return nil
+	}
+	order := make([]int, len(fresh))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return created[order[a]] < created[order[b]] })
+	for _, i := range order {
+		fresh[i]() // already seen ones are skipped
+	}
+	w.seen, w.files = listed, listedFiles
+	return nil
+}
+
+// whether an item is new, marking it seen
+func (w *watcher) fresh(kind string, id string) bool {
+	key := kind + "/" + id
+	if w.seen[key] {
+		return false
+	}
+	w.seen[key] = true
+	return true
+}
+
+func (w *watcher) text(t wapb.Text) {
+	if !w.fresh(wapb.KindText, t.ID) {
+		return
+	}
+	preview, ok := contentPreview(t.StoredCommon, t.Text)
+	w.show(t.StoredCommon, wapb.KindText, preview)
+	if ok && w.clip != nil {
+		w.report(w.clip.Write(t.Text))
+	}
+}
+
+func (w *watcher) link(l wapb.Redirect) {
+	if !w.fresh(wapb.KindLink, l.ID) {
+		return
+	}
+	preview, ok := contentPreview(l.StoredCommon, l.URL)
+	w.show(l.StoredCommon, wapb.KindLink, preview)
+	if ok && w.clip != nil {
+		w.report(w.clip.Write(l.URL))
+	}
+	if ok && w.open {
+		w.report(openBrowser(l.URL))
+	}
+}
+
+// file groups are shown when made, and again for every file added
+func (w *watcher) file(f wapb.File) {
+	if w.fresh(wapb.KindFile, f.ID) && len(f.Files) == 0 {
+		preview, _ := contentPreview(f.StoredCommon, "(no files yet)")
+		w.show(f.StoredCommon, wapb.KindFile, preview)
+	}
+	for _, file := range f.Files {
+		if w.files[file.ID] {
+			continue
+		}
+		w.files[file.ID] = true
+		preview, ok := contentPreview(f.StoredCommon, fmt.Sprintf("%s (%d bytes)", file.FileName, file.Size))
+		w.show(f.StoredCommon, wapb.KindFile, preview)
+		if ok && w.dir != "" {
+			w.report(w.download(f.ID, file))
+		}
+	}
+}
+
+// one line per new item, like  15:04:05  text  http://host/text/abc123  preview
+func (w *watcher) show(common wapb.StoredCommon, kind string, preview string) {
+	at := time.Unix(common.Created, 0).Format("15:04:05")
+	fmt.Printf("%s\t%s\t%s\t%s\n", at, kind, w.c.ItemURL(kind, common.ID, nil), preview)
+}
+
+func (w *watcher) report(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+	}
+}
+
+// a one line preview of an item's contents, and whether they can be acted
+// on. Listings leave out the contents of protected and burn after reading
+// items, and encrypted ones can't be read without their key
+func contentPreview(common wapb.StoredCommon, contents string) (string, bool) {
+	switch {
+	case common.Burn:
+		return "(burn after reading)", false
+	case common.Locked:
+		return "(password protected)", false
+	case common.Encrypted:
+		return "(encrypted)", false
+	}
+	line := strings.TrimSpace(contents)
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i] + " …"
+	}
+	if r := []rune(line); len(r) > 72 {
+		line = string(r[:72]) + "…"
+	}
+	return line, true
+}
+
+// saves a file into the download directory, under a new name if it is taken
+func (w *watcher) download(groupID string, file wapb.FileInfo) error {
+	body, err := w.c.Download(groupID, file.ID, "")
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	name := filepath.Base(file.FileName)
+	if name == "." || name == string(filepath.Separator) {
+		name = file.ID
+	}
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	var out *os.File
+	for i := 0; out == nil; i++ {
+		path := filepath.Join(w.dir, name)
+		if i > 0 {
+			path = filepath.Join(w.dir, base+"-"+strconv.Itoa(i)+ext)
+		}
+		out, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, body); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "saved", out.Name())
+	return out.Close()
+}
+
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
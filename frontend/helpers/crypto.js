@@ -0,0 +1,39 @@
+/**
+ * Client-side encryption for items, compatible with the wapb CLI. AES-GCM
+ * with a 256 bit key, which lives in the URL fragment and never reaches the
+ * server. Ciphertext is base64 of the 12 byte nonce followed by the sealed data
+ */
+
+// in slices, as spreading a whole large item as arguments overflows the stack
+const toBase64 = bytes => {
+	const all = new Uint8Array(bytes)
+	const chunks = []
+	for (let i = 0; i < all.length; i += 0x8000) {
+		chunks.push(String.fromCharCode(...all.subarray(i, i + 0x8000)))
+	}
+	return btoa(chunks.join(''))
+}
+const fromBase64 = str => Uint8Array.from(atob(str), c => c.charCodeAt(0))
+
+// the key is unpadded, URL-safe base64
+const keyToString = bytes => toBase64(bytes).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '')
+const keyFromString = str => fromBase64(str.replace(/-/g, '+').replace(/_/g, '/'))
+
+const importKey = (key, usage) => crypto.subtle.importKey('raw', keyFromString(key), 'AES-GCM', false, [usage])
+
+export const newKey = () => keyToString(crypto.getRandomValues(new Uint8Array(32)))
+
+export const encrypt = async (key, text) => {
+	const nonce = crypto.getRandomValues(new Uint8Array(12))
+	const sealed = await crypto.subtle.encrypt({ name: 'AES-GCM', iv: nonce }, await importKey(key, 'encrypt'), new TextEncoder().encode(text))
+	const out = new Uint8Array(nonce.length + sealed.byteLength)
+	out.set(nonce)
+	out.set(new Uint8Array(sealed), nonce.length)
+	return toBase64(out)
+}
+
+export const decrypt = async (key, ciphertext) => {
+	const sealed = fromBase64(ciphertext)
+	const plain = await crypto.subtle.decrypt({ name: 'AES-GCM', iv: sealed.slice(0, 12) }, await importKey(key, 'decrypt'), sealed.slice(12))
+	return new TextDecoder().decode(plain)
+}
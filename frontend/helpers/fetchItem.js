@@ -0,0 +1,24 @@
+/**
+ * Fetches an item for its page. Instead of the item, protected ones resolve
+ * to what the page should ask for first: a password, or confirmation before
+ * a burn-after-read item is consumed. Reveal is always asked for, so the
+ * server leaves the choice to the reader rather than burning on page load
+ */
+export default ($http, url, { password, reveal = '' } = {}) => {
+	return $http.$get(`${url}?reveal=${encodeURIComponent(reveal)}`, {
+		headers: password ? { 'X-Password': password } : {},
+	}).catch(async e => {
+		const status = e.response && e.response.status
+		if (status == 401) {
+			return { needsPassword: true }
+		}
+		if (status == 403 && password) {
+			return { needsPassword: true, wrongPassword: true }
+		}
+		if (status == 409) {
+			const body = await e.response.json()
+			return { burn: true, revealToken: body.reveal }
+		}
+		throw e
+	})
+}
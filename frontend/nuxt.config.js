@@ -1,5 +1,25 @@
 import colors from 'vuetify/es5/util/colors'
 
+// production builds are served under whatever path wapb-server is mounted
+// at. It swaps this placeholder for that path as it serves the assets
+const basePlaceholder = '/__wapb_base__/'
+
+function placeholderBase () {
+  if (this.options.dev) {
+    return
+  }
+  this.options.router.base = basePlaceholder
+  if (this.options.app) {
+    this.options.app.basePath = basePlaceholder
+    this.options.app.assetsPath = basePlaceholder + '_nuxt/'
+  }
+  for (const link of this.options.head.link) {
+    if (link.href && link.href.startsWith('/')) {
+      link.href = basePlaceholder + link.href.slice(1)
+    }
+  }
+}
+
 export default {
   // Disable server-side rendering (https://go.nuxtjs.dev/ssr-mode)
   ssr: false,
@@ -36,6 +56,7 @@ export default {
     //'@nuxtjs/eslint-module',
     // https://go.nuxtjs.dev/vuetify
     '@nuxtjs/vuetify',
+    placeholderBase,
   ],
 
   // Modules (https://go.nuxtjs.dev/config-modules)
@@ -66,6 +87,18 @@ export default {
     },
   },
 
+  // every page is also available within a named channel, under /c/<channel>/
+  router: {
+    extendRoutes(routes) {
+      const channelRoutes = routes.map(r => ({
+        ...r,
+        name: r.name ? `channel-${r.name}` : undefined,
+        path: `/c/:channel${r.path}`,
+      }))
+      routes.push(...channelRoutes)
+    },
+  },
+
   // Build Configuration (https://go.nuxtjs.dev/config-build)
   build: {},
 
@@ -1,4 +1,53 @@
-export default({}, inject) => {
-	const server = location.origin !== "http://localhost:3000" ? location.origin : "http://localhost:7473"
+export default({ $http, app }, inject) => {
+	// the app may be mounted under a path, which the router knows as its base
+	const origin = location.origin !== "http://localhost:3000" ? location.origin : "http://localhost:7473"
+	const server = origin + app.router.options.base.replace(/\/$/, '')
 	inject('server', server)
-}
\ No newline at end of file
+
+	// API base and page paths, scoped to a channel. No channel is the default one
+	inject('api', channel => channel ? `${server}/api/v1/c/${channel}` : `${server}/api/v1`)
+	inject('path', (channel, path) => channel ? `/c/${channel}${path}` : path)
+
+	// API token, for servers with auth enabled. Links can't send headers, so
+	// tokenQuery() is there to be appended to them
+	const token = localStorage.getItem('wapb-token')
+	if (token) {
+		$http.setToken(token, 'Bearer')
+	}
+	inject('token', token)
+	inject('tokenQuery', () => token ? `?token=${encodeURIComponent(token)}` : '')
+
+	// owner tokens for items created from this browser, needed to delete or modify them
+	const ownerKey = (channel, type, id) => `wapb-owner-${channel || ''}-${type}-${id}`
+	inject('owner', {
+		save: (channel, type, id, owner) => owner && localStorage.setItem(ownerKey(channel, type, id), owner),
+		headers: (channel, type, id) => {
+			const owner = localStorage.getItem(ownerKey(channel, type, id))
+			return owner ? { 'X-Owner-Token': owner } : {}
+		},
+	})
+
+	// passwords for protected items, kept for the session. Links can't send
+	// headers, so query() is there to be appended to them
+	const passwordKey = (channel, type, id) => `wapb-password-${channel || ''}-${type}-${id}`
+	const password = {
+		save: (channel, type, id, pw) => pw && sessionStorage.setItem(passwordKey(channel, type, id), pw),
+		get: (channel, type, id) => sessionStorage.getItem(passwordKey(channel, type, id)),
+		headers: (channel, type, id) => {
+			const pw = password.get(channel, type, id)
+			return pw ? { 'X-Password': pw } : {}
+		},
+		query: (channel, type, id) => {
+			const pw = password.get(channel, type, id)
+			return pw ? `password=${encodeURIComponent(pw)}` : ''
+		},
+	}
+	inject('password', password)
+
+	// keys of encrypted items created from this browser, so they can still be opened
+	const keyKey = (channel, type, id) => `wapb-key-${channel || ''}-${type}-${id}`
+	inject('keys', {
+		save: (channel, type, id, key) => key && localStorage.setItem(keyKey(channel, type, id), key),
+		get: (channel, type, id) => localStorage.getItem(keyKey(channel, type, id)),
+	})
+}
@@ -6,9 +6,13 @@ require (
 	github.com/dgraph-io/badger/v2 v2.2007.2
 	github.com/go-chi/chi v4.0.2+incompatible
 	github.com/json-iterator/go v1.1.10
+	github.com/pelletier/go-toml v1.9.5
 	github.com/pzl/mstk v0.0.0-20200107022131-6ad83d2e8eb8
 	github.com/shurcooL/httpfs v0.0.0-20190707220628-8d4bc4ba7749 // indirect
 	github.com/shurcooL/vfsgen v0.0.0-20200824052919-0d455de96546
 	github.com/sirupsen/logrus v1.7.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/spf13/pflag v1.0.5
+	golang.org/x/crypto v0.0.0-20201016220609-9e8e0b390897
+	gopkg.in/yaml.v2 v2.4.0
 )
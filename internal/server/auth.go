@@ -0,0 +1,202 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Scope is a set of permissions granted to an API token
+type Scope byte
+
+const (
+	ScopeRead Scope = 1 << iota
+	ScopeCreate
+	ScopeDelete
+	ScopeAdmin // everything, including debug and bulk data routes
+)
+
+var scopeNames = map[string]Scope{
+	"read":   ScopeRead,
+	"create": ScopeCreate,
+	"delete": ScopeDelete,
+	"admin":  ScopeAdmin,
+}
+
+func (s Scope) Has(scope Scope) bool { return s&ScopeAdmin != 0 || s&scope == scope }
+
+// ParseScopes reads a comma separated list of scope names
+func ParseScopes(list string) (Scope, error) {
+	var s Scope
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		scope, exists := scopeNames[name]
+		if !exists {
+			return 0, fmt.Errorf("unknown scope %q", name)
+		}
+		s |= scope
+	}
+	if s == 0 {
+		return 0, fmt.Errorf("no scopes given")
+	}
+	return s, nil
+}
+
+// Token is an API token, and what it may do
+type Token struct {
+	Name   string
+	Scopes Scope
+	hash   [sha256.Size]byte
+}
+
+func NewToken(name string, secret string, scopes Scope) Token {
+	return Token{
+		Name:   name,
+		Scopes: scopes,
+		hash:   sha256.Sum256([]byte(secret)),
+	}
+}
+
+// ParseToken reads a token definition of the form  name:secret:scope,scope
+func ParseToken(def string) (Token, error) {
+	parts := strings.SplitN(strings.TrimSpace(def), ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return Token{}, fmt.Errorf("token should be given as name:secret:scopes")
+	}
+	scopes, err := ParseScopes(parts[2])
+	if err != nil {
+		return Token{}, fmt.Errorf("token %s: %w", parts[0], err)
+	}
+	return NewToken(parts[0], parts[1], scopes), nil
+}
+
+// LoadTokens reads token definitions from a file, one per line, in the same
+// form as ParseToken. Blank lines and lines starting with # are skipped
+func LoadTokens(path string) ([]Token, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tokens := make([]Token, 0, 5)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		def := strings.TrimSpace(scanner.Text())
+		if def == "" || strings.HasPrefix(def, "#") {
+			continue
+		}
+		t, err := ParseToken(def)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, scanner.Err()
+}
+
+type ctxKey int
+
+const (
+	tokenCtxKey ctxKey = iota
+	forwardedCtxKey
+	queryTokenCtxKey
+)
+
+// the API token a request was authorized with. nil when auth is disabled
+func requestToken(r *http.Request) *Token {
+	t, _ := r.Context().Value(tokenCtxKey).(*Token)
+	return t
+}
+
+// takes the token query parameter out of a request, so its secret is never
+// logged, and keeps it for findToken on GET and HEAD only: links can't send
+// headers, but nothing else needs one in the URL
+func stripQueryToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if _, ok := q["token"]; !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		secret := q.Get("token")
+		q.Del("token")
+		r.URL.RawQuery = q.Encode()
+		if i := strings.IndexByte(r.RequestURI, '?'); i >= 0 {
+			r.RequestURI = r.RequestURI[:i]
+			if r.URL.RawQuery != "" {
+				r.RequestURI += "?" + r.URL.RawQuery
+			}
+		}
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			r = r.WithContext(context.WithValue(r.Context(), queryTokenCtxKey, secret))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// finds the configured token matching the secret sent with a request as a
+// bearer token. A token query parameter (for download links) is read-only,
+// whatever the scopes of the token it names
+func (s *Server) findToken(r *http.Request) *Token {
+	var secret string
+	fromQuery := false
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		secret = strings.TrimPrefix(auth, "Bearer ")
+	} else {
+		secret, fromQuery = r.Context().Value(queryTokenCtxKey).(string)
+	}
+	if secret == "" {
+		return nil
+	}
+
+	hash := sha256.Sum256([]byte(secret))
+	for i := range s.Tokens {
+		if subtle.ConstantTimeCompare(hash[:], s.Tokens[i].hash[:]) != 1 {
+			continue
+		}
+		if fromQuery {
+			if !s.Tokens[i].Scopes.Has(ScopeRead) {
+				return nil
+			}
+			return &Token{Name: s.Tokens[i].Name, Scopes: ScopeRead}
+		}
+		return &s.Tokens[i]
+	}
+	return nil
+}
+
+// middleware requiring a token with the given scope. With no tokens
+// configured, auth is disabled and everything is allowed
+func (s *Server) require(scope Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(s.Tokens) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			t := s.findToken(r)
+			if t == nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="wapb"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if !t.Scopes.Has(scope) {
+				s.Log.WithField("token", t.Name).Debug("token lacks scope for request")
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenCtxKey, t)))
+		})
+	}
+}
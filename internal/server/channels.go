@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/go-chi/chi"
+)
+
+// Channel is a named namespace of items, and how many of each type it holds
+type Channel struct {
+	Name string `json:"name"`
+	Text int    `json:"text"`
+	Link int    `json:"link"`
+	File int    `json:"file"`
+}
+
+var validChannel = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,64}$`)
+
+// the channel a request is scoped to. "" is the default channel
+func channel(r *http.Request) string {
+	return chi.URLParam(r, "channel")
+}
+
+// the route prefix for a channel, for building URLs
+func channelPath(ch string) string {
+	if ch == "" {
+		return ""
+	}
+	return "/c/" + ch
+}
+
+// rejects requests to malformed channel names
+func checkChannel(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !validChannel.MatchString(channel(r)) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// lists all named channels holding any items. Channels exist as long as they
+// have something in them. Hidden items are not counted
+func listChannels(db *badger.DB) ([]Channel, error) {
+	total := make([]Channel, 0, 5)
+
+	pfx := []byte{byte(StorageChannelKey)}
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = pfx
+	err := db.View(func(tx *badger.Txn) error {
+		it := tx.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(pfx); it.ValidForPrefix(pfx); it.Next() {
+			ch, sk, _, ok := parseKey(it.Item().Key())
+			if _, item := typeNames[sk]; !ok || !item {
+				continue // search index entries don't make a channel
+			}
+			if UMField(it.Item().UserMeta()).Has(Hidden) {
+				continue // nor do hidden items, alone
+			}
+			if len(total) == 0 || total[len(total)-1].Name != ch {
+				total = append(total, Channel{Name: ch})
+			}
+			c := &total[len(total)-1]
+			switch sk {
+			case StorageTextKey:
+				c.Text++
+			case StorageLinkKey:
+				c.Link++
+			case StorageFileGroupKey:
+				c.File++
+			}
+		}
+		return nil
+	})
+
+	return total, err
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+)
+
+// Events tell watching clients about items as they are made, over a
+// server-sent events stream per channel. Each carries the item as it is
+// listed, so hidden items aren't announced, and burn-after-read or password
+// protected ones come without their contents
+
+const (
+	EventCreated  = "created"  // a new item
+	EventUploaded = "uploaded" // files added to a group
+
+	eventBuffer    = 16 // events held for a slow client, before it misses some
+	eventKeepalive = 25 * time.Second
+)
+
+// Event is a change to an item, as sent to watchers
+type Event struct {
+	Name string          `json:"-"`
+	Type string          `json:"type"`
+	Item json.RawMessage `json:"item"`
+}
+
+// passes events to the watchers of a channel
+type eventHub struct {
+	mu     sync.Mutex
+	subs   map[chan Event]string // to the channel watched
+	closed bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[chan Event]string)}
+}
+
+// starts watching a channel. The returned channel is closed on shutdown
+func (h *eventHub) subscribe(ch string) chan Event {
+	events := make(chan Event, eventBuffer)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(events)
+		return events
+	}
+	h.subs[events] = ch
+	return events
+}
+
+func (h *eventHub) unsubscribe(events chan Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[events]; ok {
+		delete(h.subs, events)
+		close(events)
+	}
+}
+
+// sends an event to a channel's watchers, dropping it for any too far behind
+func (h *eventHub) publish(ch string, e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for events, watched := range h.subs {
+		if watched != ch {
+			continue
+		}
+		select {
+		case events <- e:
+		default:
+		}
+	}
+}
+
+// ends every watch, so streams don't hold up shutdown
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for events := range h.subs {
+		delete(h.subs, events)
+		close(events)
+	}
+}
+
+// tells watchers about an item, as it is now listed
+func (s *Server) publish(name string, ch string, sk StorageKey, id string) {
+	var buf []byte
+	var listed bool
+	err := s.DB.View(func(tx *badger.Txn) error {
+		item, err := tx.Get(makeKey(ch, sk, id))
+		if err != nil {
+			return err
+		}
+		buf, listed, err = listValue(sk, item)
+		return err
+	})
+	if err != nil {
+		s.Log.WithError(err).WithField("id", id).Warn("unable to announce item")
+		return
+	}
+	if listed {
+		s.events.publish(ch, Event{Name: name, Type: typeNames[sk], Item: buf})
+	}
+}
+
+// GET /events streams events for the channel, until the client leaves
+func (s *Server) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	events := s.events.subscribe(channel(r))
+	defer s.events.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // for nginx
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("retry: 2000\n\n"))
+	flusher.Flush()
+
+	// the server's write timeout would cut the stream off mid event. It is
+	// ended cleanly before that, for the client to reconnect
+	var end <-chan time.Time
+	if s.Http.WriteTimeout > 0 {
+		t := time.NewTimer(s.Http.WriteTimeout - s.Http.WriteTimeout/10)
+		defer t.Stop()
+		end = t.C
+	}
+
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			buf, err := jsCfg.Marshal(e)
+			if err != nil {
+				s.Log.WithError(err).Error("unable to serialize event")
+				continue
+			}
+			w.Write([]byte("event: " + e.Name + "\ndata: "))
+			w.Write(buf)
+			w.Write([]byte("\n\n"))
+		case <-keepalive.C:
+			w.Write([]byte(": keepalive\n\n"))
+		case <-end:
+			return
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
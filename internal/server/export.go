@@ -0,0 +1,316 @@
+package server
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// ExportRecord is a single line of an NDJSON export. Items carry their
+// stored JSON in Data. File blobs either carry their contents inline
+// (base64, via Content), or point to a sidecar file in a tar export (Path)
+type ExportRecord struct {
+	Type     string              `json:"type"`
+	ID       string              `json:"id"`
+	Channel  string              `json:"channel,omitempty"`
+	Meta     UMField             `json:"meta,omitempty"`
+	Expires  int64               `json:"expires,omitempty"` // unix timestamp. 0 means never
+	Data     jsoniter.RawMessage `json:"data,omitempty"`
+	Content  []byte              `json:"content,omitempty"`
+	Path     string              `json:"path,omitempty"`
+	Owner    []byte              `json:"owner,omitempty"`    // hash of the owner token
+	Password []byte              `json:"password,omitempty"` // hash of the item password
+}
+
+// the export field holding each value stored alongside an item
+func (rec *ExportRecord) aux(aux StorageKey) *[]byte {
+	switch aux {
+	case StorageOwnerKey:
+		return &rec.Owner
+	case StoragePasswordKey:
+		return &rec.Password
+	}
+	return nil
+}
+
+const (
+	exportItemsFile = "items.ndjson"
+	exportFilesDir  = "files"
+)
+
+// order matters on import: blobs should exist before the groups pointing at them
+var exportOrder = []StorageKey{StorageFileKey, StorageFileGroupKey, StorageLinkKey, StorageTextKey}
+
+// Export writes every stored item as NDJSON. When sidecar is true, the
+// output is instead a tar archive holding items.ndjson, with file contents
+// stored next to it under files/
+func Export(db *badger.DB, w io.Writer, sidecar bool) error {
+	return db.View(func(tx *badger.Txn) error {
+		if !sidecar {
+			return exportRecords(tx, w, false)
+		}
+
+		// records are small, buffer them so the tar header knows the size
+		var items bytes.Buffer
+		if err := exportRecords(tx, &items, true); err != nil {
+			return err
+		}
+
+		tw := tar.NewWriter(w)
+		now := time.Now()
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    exportItemsFile,
+			Mode:    0644,
+			Size:    int64(items.Len()),
+			ModTime: now,
+		}); err != nil {
+			return err
+		}
+		if _, err := items.WriteTo(tw); err != nil {
+			return err
+		}
+
+		err := eachItem(tx, typePrefix("", StorageFileKey), true, func(_ string, _ StorageKey, id string, item *badger.Item) error {
+			if err := tw.WriteHeader(&tar.Header{
+				Name:    path.Join(exportFilesDir, id),
+				Mode:    0644,
+				Size:    item.ValueSize(),
+				ModTime: now,
+			}); err != nil {
+				return err
+			}
+			return item.Value(func(v []byte) error {
+				_, err := tw.Write(v)
+				return err
+			})
+		})
+		if err != nil {
+			return err
+		}
+		return tw.Close()
+	})
+}
+
+func exportRecords(tx *badger.Txn, w io.Writer, sidecar bool) error {
+	enc := jsCfg.NewEncoder(w)
+
+	prefixes := make([][]byte, 0, len(exportOrder)+1)
+	for _, sk := range exportOrder {
+		prefixes = append(prefixes, typePrefix("", sk))
+	}
+	prefixes = append(prefixes, []byte{byte(StorageChannelKey)}) // every named channel
+
+	for _, pfx := range prefixes {
+		prefetch := StorageKey(pfx[0]) != StorageFileKey || !sidecar
+		err := eachItem(tx, pfx, prefetch, func(ch string, sk StorageKey, id string, item *badger.Item) error {
+			if _, known := typeNames[sk]; !known {
+				return nil
+			}
+			rec := ExportRecord{
+				Type:    typeNames[sk],
+				ID:      id,
+				Channel: ch,
+				Meta:    UMField(item.UserMeta()),
+				Expires: int64(item.ExpiresAt()),
+			}
+			for _, aux := range auxKeys {
+				v, err := tx.Get(auxKey(aux, item.Key()))
+				if err == badger.ErrKeyNotFound {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if *rec.aux(aux), err = v.ValueCopy(nil); err != nil {
+					return err
+				}
+			}
+			switch {
+			case sk != StorageFileKey:
+				v, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				rec.Data = v
+			case sidecar:
+				rec.Path = path.Join(exportFilesDir, id)
+			default:
+				v, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				rec.Content = v
+			}
+			return enc.Encode(rec)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// iterates every item under a key prefix, passing along the parsed key
+func eachItem(tx *badger.Txn, pfx []byte, prefetch bool, cb func(string, StorageKey, string, *badger.Item) error) error {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = prefetch
+	opts.Prefix = pfx
+	it := tx.NewIterator(opts)
+	defer it.Close()
+	for it.Seek(pfx); it.ValidForPrefix(pfx); it.Next() {
+		ch, sk, id, ok := parseKey(it.Item().Key())
+		if !ok {
+			continue
+		}
+		if err := cb(ch, sk, id, it.Item()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Import reads an export made by Export, in either format, and writes the
+// records back, preserving IDs, flags and expiration time. Records which
+// have expired in the meantime are skipped. Returns the number of records
+// written
+func Import(db *badger.DB, r io.Reader) (int, error) {
+	bufd := bufio.NewReader(r)
+
+	// tar archives have a magic value in the first header block
+	if pk, _ := bufd.Peek(262); len(pk) == 262 && string(pk[257:262]) == "ustar" {
+		return importTar(db, bufd)
+	}
+	return importRecords(db, bufd, nil)
+}
+
+func importTar(db *badger.DB, r io.Reader) (int, error) {
+	tr := tar.NewReader(r)
+	sidecars := map[string]ExportRecord{}
+	total := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return total, err
+		}
+
+		name := path.Clean(hdr.Name)
+		if name == exportItemsFile {
+			n, err := importRecords(db, tr, sidecars)
+			total += n
+			if err != nil {
+				return total, err
+			}
+			continue
+		}
+
+		rec, exists := sidecars[name]
+		if !exists {
+			if strings.HasPrefix(name, exportFilesDir+"/") {
+				return total, fmt.Errorf("file %s found before %s, or without a record", name, exportItemsFile)
+			}
+			continue // not ours
+		}
+		delete(sidecars, name)
+
+		if rec.Content, err = ioutil.ReadAll(tr); err != nil {
+			return total, err
+		}
+		written, err := importRecord(db, rec)
+		if err != nil {
+			return total, err
+		}
+		if written {
+			total++
+		}
+	}
+
+	if len(sidecars) > 0 {
+		return total, fmt.Errorf("%d file records were missing their contents", len(sidecars))
+	}
+	return total, nil
+}
+
+// when sidecars is non-nil, records with a Path are placed there to be
+// imported once their contents are found
+func importRecords(db *badger.DB, r io.Reader, sidecars map[string]ExportRecord) (int, error) {
+	dec := jsCfg.NewDecoder(r)
+	total := 0
+	for line := 1; dec.More(); line++ {
+		var rec ExportRecord
+		if err := dec.Decode(&rec); err != nil {
+			return total, fmt.Errorf("record %d: %w", line, err)
+		}
+
+		if rec.Path != "" {
+			if sidecars == nil {
+				return total, fmt.Errorf("record %d: refers to file %s, but this is not a tar archive", line, rec.Path)
+			}
+			sidecars[path.Clean(rec.Path)] = rec
+			continue
+		}
+
+		written, err := importRecord(db, rec)
+		if err != nil {
+			return total, fmt.Errorf("record %d: %w", line, err)
+		}
+		if written {
+			total++
+		}
+	}
+	return total, nil
+}
+
+// writes a single record. Returns false if it was skipped for having expired
+func importRecord(db *badger.DB, rec ExportRecord) (bool, error) {
+	sk, ok := typeByName(rec.Type)
+	if !ok {
+		return false, fmt.Errorf("unknown record type %q", rec.Type)
+	}
+	if rec.ID == "" {
+		return false, errors.New("record is missing an ID")
+	}
+	if rec.Channel != "" && (sk == StorageFileKey || !validChannel.MatchString(rec.Channel)) {
+		return false, fmt.Errorf("invalid channel %q for %s %s", rec.Channel, rec.Type, rec.ID)
+	}
+	if rec.Expires > 0 && rec.Expires <= time.Now().Unix() {
+		return false, nil // expired since the export. nothing to restore
+	}
+
+	val := []byte(rec.Data)
+	if sk == StorageFileKey {
+		val = rec.Content
+	} else if len(val) == 0 {
+		return false, fmt.Errorf("%s %s is missing its data", rec.Type, rec.ID)
+	}
+
+	key := makeKey(rec.Channel, sk, rec.ID)
+	entry := badger.NewEntry(key, val).WithMeta(byte(rec.Meta))
+	entry.ExpiresAt = uint64(rec.Expires)
+	return true, db.Update(func(tx *badger.Txn) error {
+		for _, aux := range auxKeys {
+			v := *rec.aux(aux)
+			if len(v) == 0 {
+				continue
+			}
+			e := badger.NewEntry(auxKey(aux, key), v)
+			e.ExpiresAt = uint64(rec.Expires)
+			if err := tx.SetEntry(e); err != nil {
+				return err
+			}
+		}
+		return tx.SetEntry(entry)
+	})
+}
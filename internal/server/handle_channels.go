@@ -0,0 +1,18 @@
+package server
+
+import (
+	"net/http"
+)
+
+func (s *Server) ChannelListHandler(w http.ResponseWriter, r *http.Request) {
+	channels, err := listChannels(s.DB)
+	if err != nil {
+		s.Log.WithError(err).Error("unable to list channels")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	jsCfg.NewEncoder(w).Encode(map[string]interface{}{
+		"data": channels,
+	})
+}
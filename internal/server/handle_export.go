@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+)
+
+// ExportHandler dumps every item as NDJSON. With ?format=tar, a tar archive
+// is sent instead, with file contents as sidecar files
+func (s *Server) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	sidecar := r.URL.Query().Get("format") == "tar"
+
+	if sidecar {
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.Header().Set("Content-Disposition", `attachment; filename="wapb-export.tar"`)
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="wapb-export.ndjson"`)
+	}
+
+	// once streaming has begun, the status can't be changed. Only log
+	if err := Export(s.DB, w, sidecar); err != nil {
+		s.Log.WithError(err).Error("error exporting records")
+	}
+}
+
+// ImportHandler loads records from an NDJSON or tar export
+func (s *Server) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	n, err := Import(s.DB, r.Body)
+	if err != nil {
+		s.Log.WithError(err).WithField("imported", n).Error("error importing records")
+		w.WriteHeader(http.StatusBadRequest)
+		jsCfg.NewEncoder(w).Encode(map[string]interface{}{
+			"imported": n,
+			"error":    err.Error(),
+		})
+		return
+	}
+	s.Log.WithField("imported", n).Info("imported records")
+	if _, err := RebuildIndex(s.DB); err != nil {
+		s.Log.WithError(err).Error("unable to rebuild search index after import")
+	}
+
+	jsCfg.NewEncoder(w).Encode(map[string]interface{}{
+		"imported": n,
+	})
+}
+
+// ReindexHandler rebuilds the search index from scratch
+func (s *Server) ReindexHandler(w http.ResponseWriter, r *http.Request) {
+	n, err := RebuildIndex(s.DB)
+	if err != nil {
+		s.Log.WithError(err).Error("unable to rebuild search index")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	s.Log.WithField("indexed", n).Info("rebuilt search index")
+
+	jsCfg.NewEncoder(w).Encode(map[string]interface{}{
+		"indexed": n,
+	})
+}
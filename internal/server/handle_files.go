@@ -1,18 +1,67 @@
 package server
 
 import (
+	"fmt"
 	"io"
 	"io/ioutil"
 	"mime"
 	"mime/multipart"
 	"net/http"
-	"path/filepath"
+	"net/url"
+	"path"
+	"strings"
 
 	"github.com/dgraph-io/badger/v2"
 	"github.com/go-chi/chi"
 	"github.com/sirupsen/logrus"
 )
 
+// longest form field taken alongside files, as in a multipart create
+const maxFormValue = 64 << 10
+
+// DefaultMaxUpload is the most bytes one request may upload, files and all.
+// Each file is held in memory while it is stored
+const DefaultMaxUpload = 512 << 20
+
+// the body of an upload request, cut off at the server's upload limit
+type uploadBody struct {
+	io.ReadCloser
+	max      int64
+	read     int64
+	exceeded bool // the limit was hit. The client is told so, whatever error it caused
+}
+
+func (b *uploadBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if err != nil && err != io.EOF && b.read >= b.max {
+		b.exceeded = true
+	}
+	return n, err
+}
+
+// limits the body of an upload to s.MaxUpload bytes. Going past it fails
+// the read, and closes the connection after the response
+func (s *Server) limitUpload(w http.ResponseWriter, r *http.Request) *uploadBody {
+	body := &uploadBody{ReadCloser: r.Body, max: s.MaxUpload}
+	if s.MaxUpload > 0 {
+		body.ReadCloser = http.MaxBytesReader(w, r.Body, s.MaxUpload)
+		r.Body = body
+	}
+	return body
+}
+
+// responds to a failed upload, with a 413 when it was too large
+func (s *Server) uploadFailed(w http.ResponseWriter, body *uploadBody, err error) {
+	if body.exceeded {
+		s.Log.WithError(err).Debug("rejecting upload over the size limit")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		jsCfg.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("uploads may be at most %d bytes", s.MaxUpload)})
+		return
+	}
+	s.createFailed(w, err)
+}
+
 type FileGroup struct {
 	CommonFields
 	Files []File `json:"files,omitempty"`
@@ -27,15 +76,27 @@ type File struct {
 }
 
 func (s *Server) FileGroupListHandler(w http.ResponseWriter, r *http.Request) {
-	s.doListHandler(w, StorageFileGroupKey)
+	s.doListHandler(w, r, StorageFileGroupKey)
 }
 func (s *Server) FileGroupGetHandler(w http.ResponseWriter, r *http.Request) {
 	s.doGetOneHandler(w, r, StorageFileGroupKey, nil)
 }
 
 func (s *Server) FileGroupCreateHandler(w http.ResponseWriter, r *http.Request) {
+	// files may come along, making the group and uploading in one request
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "multipart/form-data" {
+		s.fileGroupCreateMultipart(w, r)
+		return
+	}
+
 	var cr FileGroup
+	ch := channel(r)
 
+	// query parameters, overridden by whatever the body sets
+	if err := setCommonFieldsByValues(&cr.CommonFields, r.URL.Query()); err != nil {
+		s.createFailed(w, err)
+		return
+	}
 	ct, body := getContentType(r)
 	if err := jsCfg.NewDecoder(body).Decode(&cr); err != nil && err != io.EOF {
 		s.Log.WithError(err).Error("error decoding filegroup create body")
@@ -43,42 +104,179 @@ func (s *Server) FileGroupCreateHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if err := s.setTTL(&cr.CommonFields); err != nil {
+		s.createFailed(w, err)
+		return
+	}
+	if err := setTags(&cr.CommonFields); err != nil {
+		s.createFailed(w, err)
+		return
+	}
+
+	// if accept not specific, then send back the same format we got
+	accept := createdAccept(r, ct)
+	if err := checkCreatedQR(r, accept); err != nil {
+		s.createFailed(w, err)
+		return
+	}
+
 	// overwrite non-user-providable fields
 	setCreateCommonFields(&cr.CommonFields)
 	cr.Files = nil
 
-	buf, err := jsCfg.Marshal(cr)
+	// the owner token is sent back here, and is needed for uploads
+	buf, ok := s.saveCreated(w, ch, StorageFileGroupKey, &cr.CommonFields, &cr)
+	if !ok {
+		return
+	}
+
+	if s.writeCreatedURL(w, r, accept, ch, StorageFileGroupKey, cr.ID) {
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write(buf)
+}
+
+// a multipart POST /file makes a group of its files. Form fields before the
+// first file set the group's options, as query parameters do
+func (s *Server) fileGroupCreateMultipart(w http.ResponseWriter, r *http.Request) {
+	body := s.limitUpload(w, r)
+	reader, err := r.MultipartReader()
 	if err != nil {
-		s.Log.WithError(err).Error("error serializing filegroup record")
-		w.WriteHeader(http.StatusInternalServerError)
+		s.createFailed(w, invalidInput("%v", err))
+		return
+	}
+
+	values := make(url.Values)
+	part, err := reader.NextPart()
+	for err == nil && part.FileName() == "" {
+		var v []byte
+		if v, err = ioutil.ReadAll(io.LimitReader(part, maxFormValue)); err != nil {
+			break
+		}
+		values.Add(part.FormName(), string(v))
+		part, err = reader.NextPart()
+	}
+	if err == io.EOF {
+		s.createFailed(w, invalidInput("no files were uploaded"))
+		return
+	}
+	if err != nil {
+		s.uploadFailed(w, body, invalidInput("%v", err))
 		return
 	}
+	copyValues(values, r.URL.Query())
 
-	if err := writeBytes(s.DB, StorageFileGroupKey, cr.ID, buf, makeMeta(cr.CommonFields), cr.TTL); err != nil {
-		s.Log.WithError(err).Error("error writing filegroup record")
+	fg, ok := s.createUploadGroup(w, r, values)
+	if !ok {
+		return
+	}
+	files, err := s.storeParts(reader, part, makeMeta(fg.CommonFields), fg.TTL)
+	if err != nil {
+		s.dropUploadGroup(channel(r), fg.ID)
+		s.uploadFailed(w, body, err)
+		return
+	}
+	s.uploadCreated(w, r, fg, files)
+}
+
+// PUT /file/{filename} makes a group of the single file in the body, for
+// curl -T. Options come from query parameters
+func (s *Server) FileCreateManualHandler(w http.ResponseWriter, r *http.Request) {
+	filename, err := url.PathUnescape(chi.URLParam(r, "filename"))
+	if err != nil {
+		s.createFailed(w, invalidInput("%v", err))
+		return
+	}
+
+	body := s.limitUpload(w, r)
+	fg, ok := s.createUploadGroup(w, r, r.URL.Query())
+	if !ok {
+		return
+	}
+	file, err := s.storeFile(makeMeta(fg.CommonFields), fg.TTL, "file", cleanFileName(filename), r.Header.Get("Content-Type"), body)
+	if err != nil {
+		s.dropUploadGroup(channel(r), fg.ID)
+		s.uploadFailed(w, body, err)
+		return
+	}
+	s.uploadCreated(w, r, fg, []File{file})
+}
+
+// makes the group for an upload which creates its own. On failure, the
+// error status has already been written
+func (s *Server) createUploadGroup(w http.ResponseWriter, r *http.Request, values url.Values) (FileGroup, bool) {
+	var fg FileGroup
+	if err := setCommonFieldsByValues(&fg.CommonFields, values); err != nil {
+		s.createFailed(w, err)
+		return fg, false
+	}
+	if err := s.setTTL(&fg.CommonFields); err != nil {
+		s.createFailed(w, err)
+		return fg, false
+	}
+	if err := setTags(&fg.CommonFields); err != nil {
+		s.createFailed(w, err)
+		return fg, false
+	}
+	if err := checkCreatedQR(r, uploadAccept(r)); err != nil {
+		s.createFailed(w, err)
+		return fg, false
+	}
+	setCreateCommonFields(&fg.CommonFields)
+
+	_, ok := s.saveCreated(w, channel(r), StorageFileGroupKey, &fg.CommonFields, &fg)
+	return fg, ok
+}
+
+// uploads which make their group answer plain clients with its URL
+func uploadAccept(r *http.Request) string {
+	return createdAccept(r, "text/plain")
+}
+
+// removes a group made for an upload which then failed
+func (s *Server) dropUploadGroup(ch string, id string) {
+	if err := deleteRecord(s.DB, ch, StorageFileGroupKey, id); err != nil {
+		s.Log.WithError(err).WithField("id", id).Error("unable to remove group of failed upload")
+	}
+}
+
+// adds the files of an upload to the group it made, and answers with the
+// group. Plain clients like curl get its URL, as from other paste services
+func (s *Server) uploadCreated(w http.ResponseWriter, r *http.Request, fg FileGroup, files []File) {
+	ch := channel(r)
+	stored, err := s.addFiles(ch, fg.ID, makeMeta(fg.CommonFields), files)
+	if err != nil {
+		s.Log.WithError(err).WithField("id", fg.ID).Error("error adding uploaded files to group")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	// if accept not specific, then send back the same format we got
-	accept := r.Header.Get("Accept")
-	if accept == "" || accept == "*/*" {
-		accept = ct
+	if s.writeCreatedURL(w, r, uploadAccept(r), ch, StorageFileGroupKey, fg.ID) {
+		return
 	}
 
-	if accept == "text/plain" {
-		w.Header().Set("Content-Type", accept) // any header changes must happen BEFORE WriteHeader
-		w.WriteHeader(http.StatusCreated)
-		w.Write([]byte("http://" + r.Host + "/file/" + cr.ID + "\n"))
+	// the stored group, with the owner token only this response carries
+	stored.Owner, stored.Expires, stored.Remaining = fg.Owner, fg.Expires, fg.Remaining
+	buf, err := jsCfg.Marshal(stored)
+	if err != nil {
+		s.Log.WithError(err).Error("error serializing filegroup record")
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 	w.WriteHeader(http.StatusCreated)
 	w.Write(buf)
 }
+
 func (s *Server) FileUploadHandler(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
+	ch := channel(r)
+	if !s.ownerAllowed(w, r, makeKey(ch, StorageFileGroupKey, id)) {
+		return
+	}
+
 	var fg FileGroup
-	if err := getOne(s.DB, DontBurn, StorageFileGroupKey, id, &fg); err != nil {
+	if err := getOne(s.DB, DontBurn, ch, StorageFileGroupKey, id, &fg); err != nil {
 		if err == badger.ErrKeyNotFound {
 			w.WriteHeader(http.StatusNotFound)
 			return
@@ -87,13 +285,14 @@ func (s *Server) FileUploadHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	meta, err := getMeta(s.DB, StorageFileGroupKey, id)
+	meta, err := getMeta(s.DB, ch, StorageFileGroupKey, id)
 	if err != nil {
 		s.Log.WithError(err).Error("error getting filegroup meta")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	body := s.limitUpload(w, r)
 	reader, err := r.MultipartReader()
 	if err != nil {
 		s.Log.WithError(err).Error("error preparing multipart reader")
@@ -101,58 +300,17 @@ func (s *Server) FileUploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	created := make([]File, 0, 3)
-
-	for {
-		part, err := reader.NextPart()
-		if err == io.EOF {
-			break
-		}
-		contents, err := ioutil.ReadAll(part)
-		if err != nil {
-			s.Log.WithError(err).WithField(
-				"filename", part.FileName(),
-			).Error("error reading file part reader")
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		id := newID()
-		// save contents to DB
-		if err := writeBytes(s.DB, StorageFileKey, id, contents, meta, fg.TTL); err != nil {
-			s.Log.WithError(err).Error("error writing file contents to store")
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		// save record to filegroup
-		created = append(created, File{
-			ID:       id,
-			Name:     part.FormName(),
-			FileName: part.FileName(),
-			Mime:     contentTypeForPart(part, contents),
-			Size:     int64(len(contents)),
-		})
+	created, err := s.storeParts(reader, nil, meta, fg.TTL)
+	if err != nil {
+		s.uploadFailed(w, body, err)
+		return
 	}
-
-	// re-fetch filegroup in case any changes happened
-	if err := getOne(s.DB, DontBurn, StorageFileGroupKey, id, &fg); err != nil {
+	if _, err := s.addFiles(ch, id, meta, created); err != nil {
 		if err == badger.ErrKeyNotFound {
-			s.Log.WithField("id", id).Warn("FileGroup was deleted during file upload")
-			for _, c := range created {
-				if err := deleteRecord(s.DB, StorageFileKey, c.ID); err != nil {
-					s.Log.WithError(err).WithField("fileID", c.ID).Error("while cleaning up file resources, got deletion error")
-				}
-			}
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		s.Log.WithError(err).Error("error getting filegroup record")
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	fg.Files = append(fg.Files, created...)
-	if err := writeType(s.DB, StorageFileGroupKey, fg.ID, fg, meta, fg.TTL); err != nil {
-		s.Log.WithError(err).Error("error writing filegroup record")
+		s.Log.WithError(err).Error("error adding files to filegroup")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -160,30 +318,161 @@ func (s *Server) FileUploadHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func contentTypeForPart(part *multipart.Part, contents []byte) string {
-	// if the header had something specific, go with that
-	ct := part.Header.Get("Content-Type")
-	if ct != "" && ct != "application/octet-stream" {
-		return ct
+// stores the files of a multipart upload, starting from first when it was
+// already read. On failure, those already stored are removed
+func (s *Server) storeParts(reader *multipart.Reader, first *multipart.Part, meta UMField, ttl int64) ([]File, error) {
+	created := make([]File, 0, 3)
+	part := first
+	for {
+		if part == nil {
+			var err error
+			if part, err = reader.NextPart(); err == io.EOF {
+				return created, nil
+			} else if err != nil {
+				s.removeFiles(created)
+				return nil, invalidInput("%v", err)
+			}
+		}
+		file, err := s.storeFile(meta, ttl, part.FormName(), partFileName(part), part.Header.Get("Content-Type"), part)
+		if err != nil {
+			s.removeFiles(created)
+			return nil, err
+		}
+		created = append(created, file)
+		part = nil
 	}
+}
 
-	// try to determine by extension. the next-most-explicit
-	ct = mime.TypeByExtension(filepath.Ext(part.FileName()))
-	if ct != "" && ct != "application/octet-stream" {
-		return ct
+// stores the contents of one uploaded file, with the meta and TTL of its group
+func (s *Server) storeFile(meta UMField, ttl int64, name string, filename string, ct string, body io.Reader) (File, error) {
+	contents, err := ioutil.ReadAll(body)
+	if err != nil {
+		s.Log.WithError(err).WithField("filename", filename).Error("error reading file part reader")
+		return File{}, invalidInput("reading %s: %v", filename, err)
 	}
+	id := newID()
+	if err := writeBytes(s.DB, "", StorageFileKey, id, contents, meta, ttl); err != nil {
+		return File{}, fmt.Errorf("writing file contents to store: %w", err)
+	}
+
+	// encrypted contents can't be sniffed, and should never be shown inline
+	mimetype := "application/octet-stream"
+	if !meta.Has(Encrypted) {
+		mimetype = contentTypeForFile(ct, filename, contents)
+	}
+	return File{
+		ID:       id,
+		Name:     name,
+		FileName: filename,
+		Mime:     mimetype,
+		Size:     int64(len(contents)),
+	}, nil
+}
+
+func (s *Server) removeFiles(files []File) {
+	for _, f := range files {
+		if err := deleteRecord(s.DB, "", StorageFileKey, f.ID); err != nil {
+			s.Log.WithError(err).WithField("fileID", f.ID).Error("while cleaning up file resources, got deletion error")
+		}
+	}
+}
+
+// adds stored files to a group, returning it as stored. When the group was
+// deleted meanwhile, the files are removed and badger.ErrKeyNotFound returned
+func (s *Server) addFiles(ch string, id string, meta UMField, files []File) (FileGroup, error) {
+	// re-fetch filegroup in case any changes happened. Uploads to the same
+	// group may run in parallel, and each must see the files of the others
+	s.uploads.Lock()
+	defer s.uploads.Unlock()
+	var fg FileGroup
+	key := makeKey(ch, StorageFileGroupKey, id)
+	err := s.DB.Update(func(tx *badger.Txn) error {
+		item, err := tx.Get(key)
+		if err != nil {
+			return err
+		}
+		if err := item.Value(func(v []byte) error {
+			return jsCfg.Unmarshal(v, &fg)
+		}); err != nil {
+			return err
+		}
+		fg.Files = append(fg.Files, files...)
+		buf, err := jsCfg.Marshal(fg)
+		if err != nil {
+			return err
+		}
 
-	// this will always default to *something*. octet-stream is fallback
-	return http.DetectContentType(contents[:512])
+		// the group is indexed again with its new files, replacing the entries
+		// it had before
+		if err := unindexTx(tx, ch, StorageFileGroupKey, id, item); err != nil {
+			return err
+		}
+		expires := ttlExpiry(fg.TTL)
+		entry := badger.NewEntry(key, buf).WithMeta(byte(meta))
+		entry.ExpiresAt = expires
+		if err := tx.SetEntry(entry); err != nil {
+			return err
+		}
+		return indexTx(tx, ch, StorageFileGroupKey, id, buf, meta, expires)
+	})
+	if err == badger.ErrKeyNotFound {
+		s.Log.WithField("id", id).Warn("FileGroup was deleted during file upload")
+		s.removeFiles(files)
+	}
+	if err != nil {
+		return fg, err
+	}
+	s.publish(EventUploaded, ch, StorageFileGroupKey, fg.ID)
+	return fg, nil
+}
 
+// the name of an uploaded file, keeping any relative directories it was sent
+// with, as in dir/sub/name.log. Unlike part.FileName, which drops them
+func partFileName(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil || params["filename"] == "" {
+		return part.FileName()
+	}
+	return cleanFileName(params["filename"])
 }
 
+// a relative path, with no climbing out through .. and no leading /
+func cleanFileName(name string) string {
+	name = strings.Replace(name, "\\", "/", -1)
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func (s *Server) FileGroupTouchHandler(w http.ResponseWriter, r *http.Request) {
+	s.doTouchHandler(w, r, StorageFileGroupKey)
+}
+func (s *Server) FileGroupQRHandler(w http.ResponseWriter, r *http.Request) {
+	s.doQRHandler(w, r, StorageFileGroupKey)
+}
 func (s *Server) FileGroupDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	// delete files && group
 	groupID := chi.URLParam(r, "id")
+	ch := channel(r)
+	if !s.ownerAllowed(w, r, makeKey(ch, StorageFileGroupKey, groupID)) {
+		return
+	}
+
+	if s.TrashRetention > 0 {
+		err := trashRecord(s.DB, ch, StorageFileGroupKey, groupID, s.TrashRetention)
+		if err == badger.ErrKeyNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			s.Log.WithField("groupID", groupID).WithError(err).Error("unable to move file group to trash")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
 
 	var fg FileGroup
-	if err := getOne(s.DB, DontBurn, StorageFileGroupKey, groupID, &fg); err != nil {
+	if err := getOne(s.DB, DontBurn, ch, StorageFileGroupKey, groupID, &fg); err != nil {
 		if err == badger.ErrKeyNotFound {
 			w.WriteHeader(http.StatusNotFound)
 			return
@@ -194,7 +483,7 @@ func (s *Server) FileGroupDeleteHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	for _, f := range fg.Files {
-		if err := deleteRecord(s.DB, StorageFileKey, f.ID); err != nil {
+		if err := deleteRecord(s.DB, "", StorageFileKey, f.ID); err != nil {
 			if err == badger.ErrKeyNotFound {
 				s.Log.WithFields(logrus.Fields{
 					"groupID": groupID,
@@ -208,7 +497,7 @@ func (s *Server) FileGroupDeleteHandler(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	if err := deleteRecord(s.DB, StorageFileGroupKey, groupID); err != nil {
+	if err := deleteRecord(s.DB, ch, StorageFileGroupKey, groupID); err != nil {
 		s.Log.WithField("groupID", groupID).WithError(err).Error("unable to delete file group")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -218,38 +507,81 @@ func (s *Server) FileGroupDeleteHandler(w http.ResponseWriter, r *http.Request)
 
 func (s *Server) FileContentsGetHandler(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "fid")
+	gid := chi.URLParam(r, "gid")
 
-	contents, err := getOneBytes(s.DB, nil, StorageFileKey, id)
+	meta, err := getMeta(s.DB, "", StorageFileKey, id)
 	if err == badger.ErrKeyNotFound {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 	if err != nil {
-		s.Log.WithError(err).WithField("id", id).Error("error fetching file contents")
+		s.Log.WithError(err).WithField("id", id).Error("error fetching file meta")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/octet-stream")
-
-	// if we have the group ID, we can try to get some metadata on the file
-	if gid := chi.URLParam(r, "gid"); gid != "" {
+	// through a group, the file must be one of its own, in the channel asked
+	// for. Blobs aren't scoped to either by themselves
+	var file *File
+	if gid != "" {
 		var fg FileGroup
-		if err := getOne(s.DB, DontBurn, StorageFileGroupKey, gid, &fg); err == nil {
-			for _, f := range fg.Files {
-				if f.ID == id {
-					if f.Mime != "" {
-						w.Header().Set("Content-Type", f.Mime)
-					}
-					if r.URL.Query().Get("dl") != "" {
-						w.Header().Set("Content-Disposition", `attachment; filename="`+f.FileName+`"`)
-					}
+		if err := getOne(s.DB, DontBurn, channel(r), StorageFileGroupKey, gid, &fg); err == nil {
+			for i := range fg.Files {
+				if fg.Files[i].ID == id {
+					file = &fg.Files[i]
 					break
 				}
 			}
 		}
+		if file == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	}
+
+	// protected contents are only reachable through their own group, with its
+	// password. Without a group, this is the debug route, which only a real
+	// admin token may read them through. With auth disabled, there is none
+	if meta.Has(Locked) && gid == "" {
+		if t := requestToken(r); t == nil || !t.Scopes.Has(ScopeAdmin) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+	if meta.Has(Locked) && gid != "" {
+		if !s.passwordAllowed(w, r, makeKey(channel(r), StorageFileGroupKey, gid)) {
+			return
+		}
+	}
+	if meta.Has(BurnAfterRead) && !s.revealAllowed(w, r, makeKey("", StorageFileKey, id)) {
+		return
+	}
+
+	// reads through a group count as its views. The debug route only looks
+	var contents []byte
+	if gid != "" {
+		err = viewFile(s.DB, channel(r), gid, id, func(b []byte) error {
+			contents = b
+			return nil
+		})
+	} else {
+		contents, err = getOneBytes(s.DB, DontBurn, "", StorageFileKey, id)
+	}
+	if err == badger.ErrKeyNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err == badger.ErrConflict {
+		viewsBusy(w)
+		return
+	}
+	if err != nil {
+		s.Log.WithError(err).WithField("id", id).Error("error fetching file contents")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 
+	s.setBlobHeaders(w, r, file)
 	w.Write(contents)
 }
 
@@ -270,14 +602,10 @@ func (s *Server) FileContentsListHandler(w http.ResponseWriter, r *http.Request)
 // DEBUG route for cleaning up of leftover resources
 func (s *Server) FileContentsDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "fid")
-	if err := deleteRecord(s.DB, StorageFileKey, id); err != nil {
+	if err := deleteRecord(s.DB, "", StorageFileKey, id); err != nil {
 		s.Log.WithField("fid", id).WithError(err).Error("unable to delete file contents")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
-
-func (s *Server) FileCreateManualHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusNotImplemented)
-}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+)
+
+// SearchHandler finds items in the channel matching ?q=
+func (s *Server) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	results, err := search(s.DB, channel(r), q)
+	if err != nil {
+		s.Log.WithError(err).Error("unable to search")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	jsCfg.NewEncoder(w).Encode(map[string]interface{}{
+		"data": results,
+	})
+}
+
+// TagListHandler lists the tags in use in the channel, with item counts
+func (s *Server) TagListHandler(w http.ResponseWriter, r *http.Request) {
+	tags, err := listTags(s.DB, channel(r))
+	if err != nil {
+		s.Log.WithError(err).Error("unable to list tags")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	jsCfg.NewEncoder(w).Encode(map[string]interface{}{
+		"data": tags,
+	})
+}
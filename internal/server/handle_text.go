@@ -16,7 +16,7 @@ type Text struct {
 }
 
 func (s *Server) TextListHandler(w http.ResponseWriter, r *http.Request) {
-	s.doListHandler(w, StorageTextKey)
+	s.doListHandler(w, r, StorageTextKey)
 }
 func (s *Server) TextGetHandler(w http.ResponseWriter, r *http.Request) {
 	handlers := map[string]func([]byte){
@@ -54,8 +54,7 @@ func (s *Server) TextCreateHandler(w http.ResponseWriter, r *http.Request) {
 
 	ct, err := s.doCreateHandler(r, &cr.CommonFields, handlers)
 	if err != nil {
-		s.Log.WithError(err).Error("error creating record")
-		w.WriteHeader(http.StatusInternalServerError)
+		s.createFailed(w, err)
 		return
 	}
 
@@ -65,29 +64,19 @@ func (s *Server) TextCreateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	buf, err := jsCfg.Marshal(cr)
-	if err != nil {
-		s.Log.WithError(err).Error("error serializing text record")
-		w.WriteHeader(http.StatusInternalServerError)
+	// if accept not specific, then send back the same format we got
+	accept := createdAccept(r, ct)
+	if err := checkCreatedQR(r, accept); err != nil {
+		s.createFailed(w, err)
 		return
 	}
 
-	if err := writeBytes(s.DB, StorageTextKey, cr.ID, buf, makeMeta(cr.CommonFields), cr.TTL); err != nil {
-		s.Log.WithError(err).Error("error writing text record")
-		w.WriteHeader(http.StatusInternalServerError)
+	buf, ok := s.saveCreated(w, channel(r), StorageTextKey, &cr.CommonFields, &cr)
+	if !ok {
 		return
 	}
 
-	// if accept not specific, then send back the same format we got
-	accept := r.Header.Get("Accept")
-	if accept == "" || accept == "*/*" {
-		accept = ct
-	}
-
-	if accept == "text/plain" {
-		w.Header().Set("Content-Type", accept) // any header changes must happen BEFORE WriteHeader
-		w.WriteHeader(http.StatusCreated)
-		w.Write([]byte("http://" + r.Host + "/text/" + cr.ID + "\n"))
+	if s.writeCreatedURL(w, r, accept, channel(r), StorageTextKey, cr.ID) {
 		return
 	}
 	w.WriteHeader(http.StatusCreated)
@@ -96,8 +85,19 @@ func (s *Server) TextCreateHandler(w http.ResponseWriter, r *http.Request) {
 func (s *Server) TextCreateManualHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNotImplemented)
 }
+func (s *Server) TextTouchHandler(w http.ResponseWriter, r *http.Request) {
+	s.doTouchHandler(w, r, StorageTextKey)
+}
+func (s *Server) TextQRHandler(w http.ResponseWriter, r *http.Request) {
+	s.doQRHandler(w, r, StorageTextKey)
+}
 func (s *Server) TextDeleteHandler(w http.ResponseWriter, r *http.Request) {
-	err := deleteRecord(s.DB, StorageTextKey, chi.URLParam(r, "id"))
+	ch, id := channel(r), chi.URLParam(r, "id")
+	if !s.ownerAllowed(w, r, makeKey(ch, StorageTextKey, id)) {
+		return
+	}
+
+	err := s.deleteItem(ch, StorageTextKey, id)
 	if err == badger.ErrKeyNotFound {
 		w.WriteHeader(http.StatusNotFound)
 		return
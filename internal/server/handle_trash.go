@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/go-chi/chi"
+)
+
+func (s *Server) TrashListHandler(w http.ResponseWriter, r *http.Request) {
+	items, err := listTrash(s.DB, channel(r))
+	if err != nil {
+		s.Log.WithError(err).Error("unable to list trash")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	jsCfg.NewEncoder(w).Encode(map[string]interface{}{
+		"data": items,
+	})
+}
+
+func (s *Server) TrashRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	sk, id, ok := trashParams(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if !s.ownerAllowed(w, r, trashKey(channel(r), sk, id)) {
+		return
+	}
+
+	t, err := restoreRecord(s.DB, channel(r), sk, id)
+	switch {
+	case err == badger.ErrKeyNotFound:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case err == ErrRestoreConflict:
+		w.WriteHeader(http.StatusConflict)
+		return
+	case err != nil:
+		s.Log.WithError(err).WithField("id", id).Error("unable to restore item from trash")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(t.Data)
+}
+
+func (s *Server) TrashPurgeHandler(w http.ResponseWriter, r *http.Request) {
+	sk, id, ok := trashParams(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if !s.ownerAllowed(w, r, trashKey(channel(r), sk, id)) {
+		return
+	}
+
+	err := purgeRecord(s.DB, channel(r), sk, id)
+	if err == badger.ErrKeyNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.Log.WithError(err).WithField("id", id).Error("unable to purge item from trash")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) TrashEmptyHandler(w http.ResponseWriter, r *http.Request) {
+	// purges everyone's items at once, so only a real admin token may. With
+	// auth disabled there is none, and items leave the trash as it expires
+	if t := requestToken(r); t == nil || !t.Scopes.Has(ScopeAdmin) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if err := emptyTrash(s.DB, channel(r)); err != nil {
+		s.Log.WithError(err).Error("unable to empty trash")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// only items may be trashed directly. Not file contents
+func trashParams(r *http.Request) (StorageKey, string, bool) {
+	sk, ok := typeByName(chi.URLParam(r, "type"))
+	if !ok || sk == StorageFileKey {
+		return 0, "", false
+	}
+	return sk, chi.URLParam(r, "id"), true
+}
@@ -6,8 +6,10 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"math/rand"
 	"mime"
 	"net/http"
@@ -18,19 +20,36 @@ import (
 
 	"github.com/dgraph-io/badger/v2"
 	"github.com/go-chi/chi"
+	"github.com/pzl/wapb/pkg/wapb"
 )
 
 type CommonFields struct {
-	BurnAfterRead bool   `json:"burn,omitempty"`
-	Hidden        bool   `json:"hidden,omitempty"`
-	TTL           int64  `json:"ttl,omitempty"`
-	ID            string `json:"id,omitempty"`
-	Created       int64  `json:"created,omitempty"` // timestamp of creation
-
+	BurnAfterRead bool     `json:"burn,omitempty"`
+	Hidden        bool     `json:"hidden,omitempty"`
+	TTL           int64    `json:"ttl,omitempty"`
+	Sliding       bool     `json:"sliding,omitempty"` // each read renews the TTL
+	ID            string   `json:"id,omitempty"`
+	Created       int64    `json:"created,omitempty"`   // timestamp of creation
+	Owner         string   `json:"owner,omitempty"`     // owner token. Only sent back on creation, never stored
+	Password      string   `json:"password,omitempty"`  // only accepted on creation, stored as a hash
+	Locked        bool     `json:"locked,omitempty"`    // password protected
+	Encrypted     bool     `json:"encrypted,omitempty"` // contents are client-side encrypted. The key never reaches the server
+	MaxViews      int64    `json:"maxViews,omitempty"`  // item is deleted after this many reads. 0 is unlimited
+	Views         int64    `json:"views,omitempty"`     // times the item was read
+	Accessed      int64    `json:"accessed,omitempty"`  // timestamp of the last read
+	Expires       int64    `json:"expires,omitempty"`   // timestamp the item goes away. Not stored, read from the entry
+	Remaining     int64    `json:"remaining,omitempty"` // seconds until then. Not stored
+	Tags          []string `json:"tags,omitempty"`
 }
 
-func (s *Server) doListHandler(w http.ResponseWriter, sk StorageKey) {
-	items, err := getAllForType(s.DB, sk)
+func (s *Server) doListHandler(w http.ResponseWriter, r *http.Request, sk StorageKey) {
+	var items [][]byte
+	var err error
+	if tags := r.URL.Query()["tag"]; len(tags) > 0 {
+		items, err = getAllTagged(s.DB, channel(r), sk, tags)
+	} else {
+		items, err = getAllForType(s.DB, channel(r), sk)
+	}
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -47,11 +66,43 @@ func (s *Server) doListHandler(w http.ResponseWriter, sk StorageKey) {
 func (s *Server) doGetOneHandler(w http.ResponseWriter, r *http.Request, sk StorageKey, handlers map[string]func([]byte)) {
 	id := chi.URLParam(r, "id")
 
-	buf, err := getOneBytes(s.DB, nil, sk, id)
+	// check before reading, so a wrong password doesn't burn the item
+	key := makeKey(channel(r), sk, id)
+	if !s.passwordAllowed(w, r, key) {
+		return
+	}
+
+	meta, err := getMeta(s.DB, channel(r), sk, id)
+	if err == badger.ErrKeyNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.Log.WithError(err).Error("error fetching record meta")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// a file group is viewed through its files' contents, so reading its
+	// listing neither counts nor burns it
+	counted := sk != StorageFileGroupKey
+	if meta.Has(BurnAfterRead) && counted && !s.revealAllowed(w, r, key) {
+		return
+	}
+
+	var buf []byte
+	if counted {
+		buf, err = getOneBytes(s.DB, nil, channel(r), sk, id)
+	} else {
+		buf, err = peekRecord(s.DB, channel(r), sk, id)
+	}
 	if err == badger.ErrKeyNotFound {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	if err == badger.ErrConflict {
+		viewsBusy(w)
+		return
+	}
 	if err != nil {
 		s.Log.WithError(err).Error("error fetching record")
 		w.WriteHeader(http.StatusBadRequest)
@@ -67,6 +118,49 @@ func (s *Server) doGetOneHandler(w http.ResponseWriter, r *http.Request, sk Stor
 	w.Write(buf)
 }
 
+// answers a read which kept losing the race to count its view against other
+// reads of the same item. Nothing was read, and it may be tried again
+func viewsBusy(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "1")
+	w.WriteHeader(http.StatusServiceUnavailable)
+}
+
+// deletes an item, or moves it into the trash when that is enabled.
+// Burn-after-read consumption does not come through here, and is always final
+func (s *Server) deleteItem(ch string, sk StorageKey, id string) error {
+	if s.TrashRetention > 0 {
+		return trashRecord(s.DB, ch, sk, id, s.TrashRetention)
+	}
+	return deleteRecord(s.DB, ch, sk, id)
+}
+
+// renews an item's TTL on request of its owner, responding with the new
+// expiration. Items which never expire are left as they are
+func (s *Server) doTouchHandler(w http.ResponseWriter, r *http.Request, sk StorageKey) {
+	ch, id := channel(r), chi.URLParam(r, "id")
+	if !s.ownerAllowed(w, r, makeKey(ch, sk, id)) {
+		return
+	}
+
+	expires, err := renewRecord(s.DB, ch, sk, id)
+	if err == badger.ErrKeyNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.Log.WithError(err).WithField("id", id).Error("unable to renew item")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var c CommonFields
+	setExpiry(&c, expires)
+	jsCfg.NewEncoder(w).Encode(map[string]int64{
+		"expires":   c.Expires,
+		"remaining": c.Remaining,
+	})
+}
+
 type CreateHandlerFunc func(io.Reader, url.Values) error
 
 func (s *Server) doCreateHandler(r *http.Request, c *CommonFields, handlers map[string]CreateHandlerFunc) (string, error) {
@@ -94,13 +188,21 @@ func (s *Server) doCreateHandler(r *http.Request, c *CommonFields, handlers map[
 
 		copyValues(values, qvals) // merge query over form data
 	}
-	setCommonFieldsByValues(c, values)
+	if err := setCommonFieldsByValues(c, values); err != nil {
+		return ct, err
+	}
 
 	if handler, exists := handlers[ct]; exists {
 		if err := handler(body, values); err != nil {
-			return ct, err
+			return ct, invalidInput("%v", err)
 		}
 	}
+	if err := s.setTTL(c); err != nil {
+		return ct, err
+	}
+	if err := setTags(c); err != nil {
+		return ct, err
+	}
 
 	// overwrite non-user-providable fields
 	setCreateCommonFields(c)
@@ -108,7 +210,56 @@ func (s *Server) doCreateHandler(r *http.Request, c *CommonFields, handlers map[
 	return ct, nil
 }
 
-func censorPreventBurn(sk StorageKey, data []byte) ([]byte, error) {
+// stores a newly created item, its owner token hash, and password hash when
+// one was given. Returns the record to send back, which alone holds the
+// owner token. On failure, the error status has already been written
+func (s *Server) saveCreated(w http.ResponseWriter, ch string, sk StorageKey, c *CommonFields, record interface{}) ([]byte, bool) {
+	password := c.Password
+	c.Password = ""
+	c.Locked = password != ""
+
+	buf, err := jsCfg.Marshal(record)
+	if err != nil {
+		s.Log.WithError(err).WithField("type", typeNames[sk]).Error("error serializing record")
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if err := writeIndexed(s.DB, ch, sk, c.ID, buf, makeMeta(*c), c.TTL); err != nil {
+		s.Log.WithError(err).WithField("type", typeNames[sk]).Error("error writing record")
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if password != "" {
+		if err := setPassword(s.DB, ch, sk, c.ID, password, c.TTL); err != nil {
+			s.Log.WithError(err).Error("error storing password hash")
+			w.WriteHeader(http.StatusInternalServerError)
+			return nil, false
+		}
+	}
+
+	if c.Owner, err = createOwner(s.DB, ch, sk, c.ID, c.TTL); err != nil {
+		s.Log.WithError(err).Error("error creating owner token")
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	w.Header().Set(OwnerHeader, c.Owner)
+	setExpiry(c, ttlExpiry(c.TTL))
+
+	if buf, err = jsCfg.Marshal(record); err != nil {
+		s.Log.WithError(err).WithField("type", typeNames[sk]).Error("error serializing record")
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	s.publish(EventCreated, ch, sk, c.ID)
+	return buf, true
+}
+
+// redacts the contents of an item, for listing items which can't be shown
+// there: burn-after-read items which would be consumed, and password
+// protected ones
+func censorContents(sk StorageKey, data []byte) ([]byte, error) {
 	switch sk {
 	case StorageFileGroupKey:
 		fg := FileGroup{}
@@ -129,6 +280,7 @@ func censorPreventBurn(sk StorageKey, data []byte) ([]byte, error) {
 		if err := jsCfg.Unmarshal(data, &l); err != nil {
 			return nil, err
 		}
+		l.URL = ""
 		return jsCfg.Marshal(l)
 	case StorageFileKey:
 		return nil, errors.New("unable to uncensor file contents")
@@ -137,7 +289,27 @@ func censorPreventBurn(sk StorageKey, data []byte) ([]byte, error) {
 	return nil, errors.New("type not found")
 }
 
-func setCommonFieldsByValues(c *CommonFields, r url.Values) {
+// ErrInvalidInput is wrapped by errors down to what the client sent, rather
+// than the server
+var ErrInvalidInput = errors.New("invalid input")
+
+func invalidInput(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{ErrInvalidInput}, args...)...)
+}
+
+// responds to a failed create. Invalid input gets a 400 saying what was wrong
+func (s *Server) createFailed(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrInvalidInput) {
+		s.Log.WithError(err).Debug("rejecting create")
+		w.WriteHeader(http.StatusBadRequest)
+		jsCfg.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	s.Log.WithError(err).Error("error creating record")
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+func setCommonFieldsByValues(c *CommonFields, r url.Values) error {
 	switch strings.ToLower(r.Get("burn")) {
 	case "true", "1", "yes", "y", "t":
 		c.BurnAfterRead = true
@@ -145,13 +317,22 @@ func setCommonFieldsByValues(c *CommonFields, r url.Values) {
 		c.BurnAfterRead = false
 	}
 
-	switch ttl, err := strconv.ParseInt(r.Get("ttl"), 10, 64); {
-	case err != nil:
-		c.TTL = 0
-	case ttl <= 0:
-		c.TTL = 0
-	default:
-		c.TTL = ttl
+	c.TTL = 0
+	if v := r.Get("ttl"); v != "" {
+		ttl, err := wapb.ParseTTL(v)
+		if err != nil {
+			return invalidInput("%v", err)
+		}
+		c.TTL = int64(ttl / time.Second)
+	}
+
+	c.Expires = 0
+	if v := r.Get("expires"); v != "" {
+		t, err := wapb.ParseExpires(v)
+		if err != nil {
+			return invalidInput("%v", err)
+		}
+		c.Expires = t.Unix()
 	}
 
 	switch strings.ToLower(r.Get("hidden")) {
@@ -160,11 +341,70 @@ func setCommonFieldsByValues(c *CommonFields, r url.Values) {
 	default:
 		c.Hidden = false
 	}
+
+	switch strings.ToLower(r.Get("sliding")) {
+	case "true", "1", "yes", "y", "t":
+		c.Sliding = true
+	default:
+		c.Sliding = false
+	}
+
+	switch strings.ToLower(r.Get("encrypted")) {
+	case "true", "1", "yes", "y", "t":
+		c.Encrypted = true
+	default:
+		c.Encrypted = false
+	}
+
+	c.MaxViews = 0
+	if v := r.Get("maxViews"); v != "" {
+		maxViews, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || maxViews < 0 {
+			return invalidInput("maxViews should be a count of views, or 0 for no limit")
+		}
+		c.MaxViews = maxViews
+	}
+
+	c.Tags = tagsByValues(r)
+	c.Password = r.Get("password")
+	return nil
+}
+
+// the longest TTL, in seconds, which still fits a time.Duration
+const maxTTL = math.MaxInt64 / int64(time.Second)
+
+// settles the TTL of an item being created, from an absolute expiration when
+// one was asked for, and the server's default and maximum
+func (s *Server) setTTL(c *CommonFields) error {
+	if c.Expires > 0 {
+		c.TTL = c.Expires - time.Now().Unix()
+		if c.TTL <= 0 {
+			return invalidInput("expiration is in the past")
+		}
+	}
+	if c.TTL < 0 {
+		return invalidInput("ttl must not be negative")
+	}
+	if c.TTL > maxTTL {
+		return invalidInput("ttl may be at most %d seconds", maxTTL)
+	}
+	if c.TTL == 0 {
+		c.TTL = int64(s.DefaultTTL / time.Second)
+	}
+	if max := int64(s.MaxTTL / time.Second); max > 0 && (c.TTL == 0 || c.TTL > max) {
+		return invalidInput("ttl may be at most %s", s.MaxTTL)
+	}
+	return nil
 }
 
 func setCreateCommonFields(c *CommonFields) {
 	c.ID = newID()
 	c.Created = time.Now().Unix()
+	c.Owner = ""
+	c.Views = 0
+	c.Accessed = 0
+	c.Expires = 0
+	c.Remaining = 0
 }
 
 func getContentType(r *http.Request) (string, io.Reader) {
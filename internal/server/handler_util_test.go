@@ -0,0 +1,116 @@
+package server
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSetTTL(t *testing.T) {
+	now := time.Now().Unix()
+	cases := []struct {
+		name    string
+		c       CommonFields
+		ttl     int64 // settled on, when valid
+		invalid bool
+	}{
+		{name: "none", c: CommonFields{}, ttl: 0},
+		{name: "seconds", c: CommonFields{TTL: 600}, ttl: 600},
+		{name: "longest", c: CommonFields{TTL: maxTTL}, ttl: maxTTL},
+		{name: "too long", c: CommonFields{TTL: maxTTL + 1}, invalid: true},
+		{name: "json overflow", c: CommonFields{TTL: 99999999999999}, invalid: true},
+		{name: "negative", c: CommonFields{TTL: -1}, invalid: true},
+		{name: "expires", c: CommonFields{Expires: now + 3600}},
+		{name: "expires past", c: CommonFields{Expires: now - 1}, invalid: true},
+		{name: "expires far off", c: CommonFields{Expires: now + maxTTL + 1000}, invalid: true},
+	}
+	s := &Server{}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := tc.c
+			err := s.setTTL(&c)
+			if tc.invalid {
+				if !errors.Is(err, ErrInvalidInput) {
+					t.Fatalf("got ttl %d and error %v, want invalid input", c.TTL, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.c.Expires > 0 {
+				// allowing for the clock ticking over
+				if d := tc.c.Expires - now - c.TTL; d < 0 || d > 1 {
+					t.Errorf("ttl %d for an expiration in %d seconds", c.TTL, tc.c.Expires-now)
+				}
+			} else if c.TTL != tc.ttl {
+				t.Errorf("ttl %d, want %d", c.TTL, tc.ttl)
+			}
+		})
+	}
+}
+
+func TestSetTTLDefaults(t *testing.T) {
+	s := &Server{DefaultTTL: time.Hour, MaxTTL: 24 * time.Hour}
+	c := CommonFields{}
+	if err := s.setTTL(&c); err != nil || c.TTL != 3600 {
+		t.Errorf("got ttl %d and error %v, want the default", c.TTL, err)
+	}
+	c = CommonFields{TTL: 2 * 24 * 3600}
+	if err := s.setTTL(&c); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("got ttl %d and error %v, want it over the maximum", c.TTL, err)
+	}
+}
+
+func TestTTLValues(t *testing.T) {
+	s := &Server{}
+	cases := map[string]bool{ // valid
+		"ttl=10m":                 true,
+		"ttl=1d12h":               true,
+		"ttl=10x":                 false,
+		"ttl=99999999999999s":     false,
+		"ttl=999999999w":          false,
+		"expires=9999999999":      true,
+		"expires=99999999999":     false,
+		"expires=999999999999999": false,
+		"expires=" + strconv.FormatInt(time.Now().Unix()+60, 10): true,
+	}
+	for query, valid := range cases {
+		values, _ := url.ParseQuery(query)
+		var c CommonFields
+		err := setCommonFieldsByValues(&c, values)
+		if err == nil {
+			err = s.setTTL(&c)
+		}
+		if valid && err != nil {
+			t.Errorf("%s: %v", query, err)
+		}
+		if !valid && !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("%s: got ttl %d and error %v, want invalid input", query, c.TTL, err)
+		}
+	}
+}
+
+func TestMaxViewsValues(t *testing.T) {
+	cases := map[string]int64{ // -1 for invalid
+		"":            0,
+		"maxViews=0":  0,
+		"maxViews=3":  3,
+		"maxViews=-1": -1,
+		"maxViews=3x": -1,
+		"maxViews=":   0,
+	}
+	for query, want := range cases {
+		values, _ := url.ParseQuery(query)
+		var c CommonFields
+		err := setCommonFieldsByValues(&c, values)
+		switch {
+		case want < 0 && !errors.Is(err, ErrInvalidInput):
+			t.Errorf("%q: got maxViews %d and error %v, want invalid input", query, c.MaxViews, err)
+		case want >= 0 && (err != nil || c.MaxViews != want):
+			t.Errorf("%q: got maxViews %d and error %v, want %d", query, c.MaxViews, err, want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// first file descriptor passed by systemd socket activation
+const listenFdsStart = 3
+
+// a socket the server accepts connections on, and the address it was asked
+// for, for reporting errors
+type listener struct {
+	net.Listener
+	addr string
+}
+
+// opens every listen address. These may be host:port, unix:/path/to.sock,
+// systemd for all sockets passed by systemd socket activation, or
+// systemd:name for the one named so by FileDescriptorName=. Without any,
+// the default address is opened for both IPv4 and IPv6
+func openListeners(addrs []string, defaultAddr string) ([]listener, error) {
+	var ls []listener
+	fail := func(err error) ([]listener, error) {
+		for _, l := range ls {
+			l.Close()
+		}
+		return nil, err
+	}
+
+	if len(addrs) == 0 {
+		for _, tp := range []string{"tcp4", "tcp6"} {
+			n, err := net.Listen(tp, defaultAddr)
+			if err != nil {
+				return fail(fmt.Errorf("listen %s %s: %w", tp, defaultAddr, err))
+			}
+			ls = append(ls, listener{n, tp + " " + defaultAddr})
+		}
+		return ls, nil
+	}
+
+	for _, addr := range addrs {
+		switch {
+		case strings.HasPrefix(addr, "unix:"):
+			n, err := listenUnix(strings.TrimPrefix(addr, "unix:"))
+			if err != nil {
+				return fail(fmt.Errorf("listen %s: %w", addr, err))
+			}
+			ls = append(ls, listener{n, addr})
+		case addr == "systemd" || strings.HasPrefix(addr, "systemd:"):
+			inherited, err := systemdListeners(strings.TrimPrefix(strings.TrimPrefix(addr, "systemd"), ":"))
+			if err != nil {
+				return fail(fmt.Errorf("listen %s: %w", addr, err))
+			}
+			ls = append(ls, inherited...)
+		default:
+			n, err := net.Listen("tcp", addr)
+			if err != nil {
+				return fail(fmt.Errorf("listen %s: %w", addr, err))
+			}
+			ls = append(ls, listener{n, addr})
+		}
+	}
+	return ls, nil
+}
+
+// listens on a unix socket, replacing one left behind by an earlier run
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("%s is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+var (
+	inheritOnce sync.Once
+	inherited   []*os.File
+	inheritErr  error
+)
+
+// the sockets passed by systemd, with the given name, or all of them when
+// name is empty. Each may only be taken once
+func systemdListeners(name string) ([]listener, error) {
+	inheritOnce.Do(func() {
+		inherited, inheritErr = systemdFiles()
+	})
+	if inheritErr != nil {
+		return nil, inheritErr
+	}
+
+	var ls []listener
+	for i, f := range inherited {
+		if f == nil || (name != "" && f.Name() != name) {
+			continue
+		}
+		n, err := net.FileListener(f)
+		f.Close() // FileListener holds its own copy
+		inherited[i] = nil
+		if err != nil {
+			return nil, fmt.Errorf("fd %d (%s): %w", listenFdsStart+i, f.Name(), err)
+		}
+		ls = append(ls, listener{n, "systemd:" + f.Name()})
+	}
+	if len(ls) == 0 {
+		if name == "" {
+			return nil, fmt.Errorf("no sockets were passed by systemd")
+		}
+		return nil, fmt.Errorf("no socket named %q was passed by systemd", name)
+	}
+	return ls, nil
+}
+
+// reads the sockets passed by systemd socket activation, as described in
+// sd_listen_fds(3). The environment is cleared so children don't take them
+func systemdFiles() ([]*os.File, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	files := make([]*os.File, n)
+	for i := range files {
+		fd := listenFdsStart + i
+		name := "unknown" // systemd's default name
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		files[i] = os.NewFile(uintptr(fd), name)
+	}
+	return files, nil
+}
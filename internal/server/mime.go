@@ -0,0 +1,98 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// DefaultInlineTypes are the content types of files shown in the browser.
+// Anything else is downloaded, so uploaded HTML, SVG and the like never run
+// scripts on the server's origin. A type ending in /* matches all its subtypes
+var DefaultInlineTypes = []string{
+	"image/png", "image/jpeg", "image/gif", "image/webp", "image/avif", "image/bmp",
+	"audio/*", "video/*",
+	"text/plain", "application/pdf",
+}
+
+// file contents are only ever shown on their own. They may load nothing,
+// and are sandboxed as if from another origin
+const blobCSP = "default-src 'none'; img-src 'self' data:; media-src 'self'; style-src 'unsafe-inline'; sandbox"
+
+// WithInlineTypes sets the content types of files shown in the browser,
+// replacing DefaultInlineTypes. Others are always sent as downloads
+func WithInlineTypes(types ...string) Option {
+	return func(s *Server) {
+		s.InlineTypes = make([]string, 0, len(types))
+		for _, t := range types {
+			s.InlineTypes = append(s.InlineTypes, strings.ToLower(strings.TrimSpace(t)))
+		}
+	}
+}
+
+// the content type an uploaded file is stored with. One the client gave
+// wins, when it is well formed and says more than octet-stream. Then the
+// extension, and last the contents themselves
+func contentTypeForFile(claimed string, filename string, contents []byte) string {
+	if mt, params, err := mime.ParseMediaType(claimed); err == nil && mt != "application/octet-stream" {
+		if ct := mime.FormatMediaType(mt, params); ct != "" {
+			return ct
+		}
+	}
+
+	ct := mime.TypeByExtension(path.Ext(filename))
+	if ct != "" && ct != "application/octet-stream" {
+		return ct
+	}
+
+	// looks at no more than the first 512 bytes, however many there are.
+	// octet-stream is the fallback
+	return http.DetectContentType(contents)
+}
+
+// whether files of a content type are shown in the browser, rather than downloaded
+func (s *Server) inline(ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	for _, t := range s.InlineTypes {
+		if t == mt || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mt, strings.TrimSuffix(t, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// sets the headers of a file's contents. Types not shown inline, and any
+// file when ?dl is given, are sent as downloads. The browser is kept from
+// second guessing the type, and the contents from doing anything if shown
+func (s *Server) setBlobHeaders(w http.ResponseWriter, r *http.Request, file *File) {
+	ct, name := "application/octet-stream", ""
+	if file != nil {
+		if file.Mime != "" {
+			ct = file.Mime
+		}
+		name = path.Base(file.FileName)
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", ct)
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Security-Policy", blobCSP)
+
+	disposition := "inline"
+	if r.URL.Query().Get("dl") != "" || !s.inline(ct) {
+		disposition = "attachment"
+	}
+	params := map[string]string{}
+	if name != "" && name != "." && name != "/" {
+		params["filename"] = name
+	}
+	if v := mime.FormatMediaType(disposition, params); v != "" {
+		h.Set("Content-Disposition", v)
+	} else {
+		h.Set("Content-Disposition", disposition) // a name that couldn't be encoded
+	}
+}
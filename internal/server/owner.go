@@ -0,0 +1,82 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+)
+
+// OwnerHeader carries an item's owner token, as returned on creation. It may
+// also be sent as the "owner" query parameter
+const OwnerHeader = "X-Owner-Token"
+
+// creates a random owner token for a new item, storing its hash alongside
+// the item. Only the returned token can modify or delete the item after this
+func createOwner(db *badger.DB, ch string, sk StorageKey, id string, ttl int64) (string, error) {
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(secret)
+	hash := sha256.Sum256([]byte(token))
+
+	entry := badger.NewEntry(auxKey(StorageOwnerKey, makeKey(ch, sk, id)), hash[:])
+	if ttl > 0 {
+		entry = entry.WithTTL(time.Duration(ttl) * time.Second)
+	}
+	return token, db.Update(func(tx *badger.Txn) error {
+		return tx.SetEntry(entry)
+	})
+}
+
+// checks a token against the owner hash stored alongside an item. Items
+// created without one are not protected
+func checkOwner(db *badger.DB, itemKey []byte, token string) (bool, error) {
+	var ok bool
+	return ok, db.View(func(tx *badger.Txn) error {
+		item, err := tx.Get(auxKey(StorageOwnerKey, itemKey))
+		if err == badger.ErrKeyNotFound {
+			ok = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256([]byte(token))
+		return item.Value(func(v []byte) error {
+			ok = subtle.ConstantTimeCompare(hash[:], v) == 1
+			return nil
+		})
+	})
+}
+
+// ensures a request may modify the item at itemKey, by sending its owner
+// token. Admin API tokens may modify anything. Writes the error status and
+// returns false when not allowed
+func (s *Server) ownerAllowed(w http.ResponseWriter, r *http.Request, itemKey []byte) bool {
+	if t := requestToken(r); t != nil && t.Scopes.Has(ScopeAdmin) {
+		return true
+	}
+
+	token := r.Header.Get(OwnerHeader)
+	if token == "" {
+		token = r.URL.Query().Get("owner")
+	}
+
+	ok, err := checkOwner(s.DB, itemKey, token)
+	if err != nil {
+		s.Log.WithError(err).Error("unable to check item owner")
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		w.WriteHeader(http.StatusForbidden)
+		return false
+	}
+	return true
+}
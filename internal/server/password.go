@@ -0,0 +1,85 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHeader carries the password for a protected item. It may also be
+// sent as the "password" query parameter
+const PasswordHeader = "X-Password"
+
+// stores a slow hash of an item's password alongside it
+func setPassword(db *badger.DB, ch string, sk StorageKey, id string, password string, ttl int64) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	entry := badger.NewEntry(auxKey(StoragePasswordKey, makeKey(ch, sk, id)), hash)
+	if ttl > 0 {
+		entry = entry.WithTTL(time.Duration(ttl) * time.Second)
+	}
+	return db.Update(func(tx *badger.Txn) error {
+		return tx.SetEntry(entry)
+	})
+}
+
+// checks a password against the hash stored alongside an item. Items
+// without one are not protected
+func checkPassword(db *badger.DB, itemKey []byte, password string) (bool, error) {
+	var hash []byte
+	err := db.View(func(tx *badger.Txn) error {
+		item, err := tx.Get(auxKey(StoragePasswordKey, itemKey))
+		if err != nil {
+			return err
+		}
+		hash, err = item.ValueCopy(nil)
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil, nil
+}
+
+func requestPassword(r *http.Request) string {
+	if pw := r.Header.Get(PasswordHeader); pw != "" {
+		return pw
+	}
+	return r.URL.Query().Get("password")
+}
+
+// ensures a request may read the item at itemKey, by sending its password.
+// Admin API tokens may read anything. Writes the error status and returns
+// false when not allowed: 401 when no password was sent, 403 when it was wrong
+func (s *Server) passwordAllowed(w http.ResponseWriter, r *http.Request, itemKey []byte) bool {
+	if t := requestToken(r); t != nil && t.Scopes.Has(ScopeAdmin) {
+		return true
+	}
+
+	password := requestPassword(r)
+	ok, err := checkPassword(s.DB, itemKey, password)
+	if err != nil {
+		s.Log.WithError(err).Error("unable to check item password")
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	if ok {
+		return true
+	}
+
+	if password == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+	} else {
+		w.WriteHeader(http.StatusForbidden)
+	}
+	w.Write([]byte(`{"locked":true}`))
+	return false
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/go-chi/chi"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	qrDefaultSize = 256 // PNG width in pixels
+	qrMaxSize     = 2048
+)
+
+// the public page URL of an item
+func (s *Server) itemURL(r *http.Request, ch string, sk StorageKey, id string) string {
+	return s.URL(r, channelPath(ch)+"/"+typeNames[sk]+"/"+id)
+}
+
+// whether a content type is one QR codes are sent as
+func qrType(ct string) bool {
+	return ct == "image/png" || ct == "image/svg+xml"
+}
+
+// the type a create request is answered with: what it accepts, or fallback
+// when it leaves that to the server
+func createdAccept(r *http.Request, fallback string) string {
+	accept := r.Header.Get("Accept")
+	if accept == "" || accept == "*/*" {
+		return fallback
+	}
+	return accept
+}
+
+// refuses a create request whose answer is a QR code with bad options, so
+// that is known before anything is stored
+func checkCreatedQR(r *http.Request, accept string) error {
+	if !qrType(accept) {
+		return nil
+	}
+	_, err := qrSize(r)
+	return err
+}
+
+// the PNG width asked for with ?size=
+func qrSize(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("size")
+	if v == "" {
+		return qrDefaultSize, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 32 || n > qrMaxSize {
+		return 0, invalidInput("size should be from 32 to %d pixels", qrMaxSize)
+	}
+	return n, nil
+}
+
+// answers a create request with where the new item can be found, as a plain
+// URL or a QR code of it, when one of those was asked for
+func (s *Server) writeCreatedURL(w http.ResponseWriter, r *http.Request, accept string, ch string, sk StorageKey, id string) bool {
+	switch {
+	case accept == "text/plain":
+		w.Header().Set("Content-Type", accept) // any header changes must happen BEFORE WriteHeader
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(s.itemURL(r, ch, sk, id) + "\n"))
+	case qrType(accept):
+		s.writeQR(w, r, accept, http.StatusCreated, s.itemURL(r, ch, sk, id))
+	default:
+		return false
+	}
+	return true
+}
+
+// GET /{type}/{id}/qr a QR code of the item's URL. PNG unless SVG is asked
+// for with ?format=svg or the Accept header. ?size= sets the PNG width
+func (s *Server) doQRHandler(w http.ResponseWriter, r *http.Request, sk StorageKey) {
+	ch, id := channel(r), chi.URLParam(r, "id")
+
+	// only checks it exists. Reading it would count as a view
+	err := s.DB.View(func(tx *badger.Txn) error {
+		_, err := tx.Get(makeKey(ch, sk, id))
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.Log.WithError(err).WithField("id", id).Error("unable to look up item")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	ct := "image/png"
+	switch strings.ToLower(r.URL.Query().Get("format")) {
+	case "svg":
+		ct = "image/svg+xml"
+	case "png":
+	case "":
+		if strings.Contains(r.Header.Get("Accept"), "image/svg+xml") {
+			ct = "image/svg+xml"
+		}
+	default:
+		s.createFailed(w, invalidInput("format should be png or svg"))
+		return
+	}
+	s.writeQR(w, r, ct, http.StatusOK, s.itemURL(r, ch, sk, id))
+}
+
+// sends a QR code of content, as a PNG or SVG
+func (s *Server) writeQR(w http.ResponseWriter, r *http.Request, ct string, status int, content string) {
+	size, err := qrSize(r)
+	if err != nil {
+		s.createFailed(w, err)
+		return
+	}
+
+	q, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		s.Log.WithError(err).Error("unable to make QR code")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var buf []byte
+	if ct == "image/svg+xml" {
+		buf = qrSVG(q.Bitmap())
+	} else if buf, err = q.PNG(size); err != nil {
+		s.Log.WithError(err).Error("unable to draw QR code")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("Content-Length", strconv.Itoa(len(buf)))
+	w.WriteHeader(status)
+	w.Write(buf)
+}
+
+// draws a QR code as SVG, one unit per module, scaling to whatever size it is shown at
+func qrSVG(bitmap [][]bool) []byte {
+	var path strings.Builder
+	for y, row := range bitmap {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			// runs of dark modules are drawn as one rectangle
+			start := x
+			for x+1 < len(row) && row[x+1] {
+				x++
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", start, y, x-start+1, x-start+1)
+		}
+	}
+	n := len(bitmap)
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="%d" height="%d" fill="#fff"/><path d="%s" fill="#000"/></svg>`+"\n", n, n, n, n, path.String()))
+}
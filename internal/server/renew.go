@@ -0,0 +1,111 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+)
+
+// when an item with the given TTL expires, if stored now. 0 for no TTL
+func ttlExpiry(ttl int64) uint64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return uint64(time.Now().Unix() + ttl)
+}
+
+// pushes an item's expiration out to its original TTL from now, and its index
+// entries' with it. File groups renew their file contents along with them. Returns the new expiration,
+// which is 0 for items that never expire, and so aren't renewed
+func renewRecord(db *badger.DB, ch string, sk StorageKey, id string) (uint64, error) {
+	var expires uint64
+	var files []File
+
+	err := db.Update(func(tx *badger.Txn) error {
+		key := makeKey(ch, sk, id)
+		item, err := tx.Get(key)
+		if err != nil {
+			return err
+		}
+		rec, ok := newRecord(sk)
+		if !ok {
+			return fmt.Errorf("unknown item type %q", sk)
+		}
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if err := jsCfg.Unmarshal(val, rec); err != nil {
+			return err
+		}
+		if rec.common().TTL <= 0 {
+			return nil
+		}
+
+		expires = ttlExpiry(rec.common().TTL)
+		entry := badger.NewEntry(key, val).WithMeta(item.UserMeta())
+		entry.ExpiresAt = expires
+		if err := tx.SetEntry(entry); err != nil {
+			return err
+		}
+		if err := indexTx(tx, ch, sk, id, val, UMField(item.UserMeta()), expires); err != nil {
+			return err
+		}
+		if fg, ok := rec.(*FileGroup); ok {
+			files = fg.Files
+		}
+		return renewAux(tx, key, expires)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return expires, renewFiles(db, files, expires)
+}
+
+// sets a new expiration on the keys stored alongside an item
+func renewAux(tx *badger.Txn, itemKey []byte, expiresAt uint64) error {
+	for _, aux := range auxKeys {
+		item, err := tx.Get(auxKey(aux, itemKey))
+		if err == badger.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		entry := badger.NewEntry(auxKey(aux, itemKey), val).WithMeta(item.UserMeta())
+		entry.ExpiresAt = expiresAt
+		if err := tx.SetEntry(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// file contents may be large. Renew them one transaction at a time
+func renewFiles(db *badger.DB, files []File, expiresAt uint64) error {
+	for _, f := range files {
+		err := db.Update(func(tx *badger.Txn) error {
+			key := makeKey("", StorageFileKey, f.ID)
+			item, err := tx.Get(key)
+			if err != nil {
+				return err
+			}
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			entry := badger.NewEntry(key, val).WithMeta(item.UserMeta())
+			entry.ExpiresAt = expiresAt
+			return tx.SetEntry(entry)
+		})
+		if err != nil && err != badger.ErrKeyNotFound {
+			return err
+		}
+	}
+	return nil
+}
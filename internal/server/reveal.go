@@ -0,0 +1,130 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RevealHeader carries the token confirming a burn-after-read item should be
+// consumed. It may also be sent as the "reveal" query parameter. Sending
+// that parameter empty asks for the token, even when the request would
+// otherwise pass straight through
+const RevealHeader = "X-Reveal-Token"
+
+// how long a reveal token may be used for
+const revealTTL = 10 * time.Minute
+
+// link preview fetchers, and crawlers in general. These always get the
+// interstitial, whatever they claim to accept
+var botAgents = []string{
+	"bot", "crawl", "spider", "slurp", "preview", "facebookexternalhit",
+	"whatsapp", "embedly", "vkshare", "pinterest", "bitlybot", "outbrain",
+	"quora link", "skypeuripreview", "nuzzel", "mastodon", "iframely",
+}
+
+func isBot(userAgent string) bool {
+	ua := strings.ToLower(userAgent)
+	for _, b := range botAgents {
+		if strings.Contains(ua, b) {
+			return true
+		}
+	}
+	return false
+}
+
+// API clients explicitly accepting JSON or text can read burnable items
+// directly. Browsers and preview fetchers accept HTML, or anything
+func passthrough(r *http.Request) bool {
+	if _, asked := r.URL.Query()["reveal"]; asked || isBot(r.UserAgent()) {
+		return false
+	}
+	accept := strings.Split(r.Header.Get("Accept"), ",")[0]
+	mt, _, _ := mime.ParseMediaType(accept)
+	return mt == "application/json" || mt == "text/plain"
+}
+
+// tokens are bound to the item, and expire. As  expiry.hmac
+func (s *Server) revealToken(itemKey []byte, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, s.revealSecret)
+	mac.Write([]byte(exp))
+	mac.Write(itemKey)
+	return exp + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Server) validReveal(itemKey []byte, token string) bool {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	exp, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(s.revealToken(itemKey, time.Unix(exp, 0))))
+}
+
+// ensures a read of a burnable item is intended, before it is consumed.
+// Without a valid reveal token, responds with a page to confirm the reveal
+// for browsers, or a 409 with the token for everything else, and returns false
+func (s *Server) revealAllowed(w http.ResponseWriter, r *http.Request, itemKey []byte) bool {
+	if passthrough(r) {
+		return true
+	}
+	token := r.Header.Get(RevealHeader)
+	if token == "" {
+		token = r.URL.Query().Get("reveal")
+	}
+	if token != "" && s.validReveal(itemKey, token) {
+		return true
+	}
+
+	token = s.revealToken(itemKey, time.Now().Add(revealTTL))
+	w.Header().Set("Cache-Control", "no-store")
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		// keep other params, like the password, on the confirming request
+		q := r.URL.Query()
+		q.Set("reveal", token)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusConflict)
+		if err := revealPage.Execute(w, q); err != nil {
+			s.Log.WithError(err).Error("unable to render reveal page")
+		}
+		return false
+	}
+
+	w.WriteHeader(http.StatusConflict)
+	jsCfg.NewEncoder(w).Encode(map[string]interface{}{
+		"burn":   true,
+		"reveal": token,
+	})
+	return false
+}
+
+// a form, rather than a link, so nothing following links can consume the item
+var revealPage = template.Must(template.New("reveal").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>wapb</title>
+</head>
+<body style="font-family: sans-serif; text-align: center; margin-top: 20vh">
+<p>This item will be deleted as soon as it is revealed.</p>
+<form method="get">
+{{- range $k, $vs := . }}{{ range $vs }}
+<input type="hidden" name="{{ $k }}" value="{{ . }}">
+{{- end }}{{ end }}
+<button type="submit">Reveal</button>
+</form>
+</body>
+</html>
+`))
@@ -13,27 +13,27 @@ import (
 type StaticHandler http.Handler
 
 func (s *Server) SetupRoutes() {
-	s.Router.Use(middleware.RealIP)
+	s.Router.Use(stripQueryToken) // before anything logs the URL
 	s.Router.Use(middleware.RequestID)
 	s.Router.Use(middleware.RequestLogger(logger.NewChi(s.Log)))
 	s.Router.Use(middleware.Heartbeat("/ping"))
 	s.Router.Use(middleware.Recoverer)
-	s.Router.Use(cors)
+	s.Router.Use(s.cors)
 
 	s.routeAPI()
 	s.routeWeb()
 }
 
 func (s *Server) routeWeb() {
-	s.Router.Get("/_nuxt/*", s.AssetHandler.ServeHTTP)
+	s.Router.Get("/_nuxt/*", s.serveAsset)
 	// add other "/" root level static files needed here
 	files := []string{"favicon.ico"}
 	for _, f := range files {
-		s.Router.Get("/"+f, s.AssetHandler.ServeHTTP)
+		s.Router.Get("/"+f, s.serveAsset)
 	}
 
 	// the above probably not necessary if we route the rest to vue
-	s.Router.Get("/*", s.AssetHandler.ServeHTTP)
+	s.Router.Get("/*", s.serveAsset)
 }
 
 func (s *Server) routeAPI() {
@@ -41,33 +41,70 @@ func (s *Server) routeAPI() {
 		v1.Use(contentJSON) // by default
 		v1.Use(mstk.APIVer(1))
 
-		v1.Get("/file", s.FileGroupListHandler)
-		v1.Post("/file", s.FileGroupCreateHandler)
-		v1.Post("/file/{id}", s.FileUploadHandler)
-		v1.Get("/file/{id}", s.FileGroupGetHandler)
-		//v1.Put("/file/{id}", s.FileCreateManualHandler)
-		v1.Delete("/file/{id}", s.FileGroupDeleteHandler)
-		v1.Get("/file/{gid}/{fid}", s.FileContentsGetHandler)
+		// the default channel lives at the root, named channels under /c/
+		s.routeItems(v1)
+		v1.With(s.require(ScopeRead)).Get("/channels", s.ChannelListHandler)
+		v1.With(checkChannel).Route("/c/{channel}", s.routeItems)
+
+		admin := v1.With(s.require(ScopeAdmin))
 
 		// debug routes to check on file blobs. Not API stable
-		v1.Get("/_contents", s.FileContentsListHandler)
-		v1.Get("/_contents/{fid}", s.FileContentsGetHandler)
-		v1.Delete("/_contents/{fid}", s.FileContentsDeleteHandler)
-
-		v1.Get("/link", s.LinkListHandler)
-		v1.Post("/link", s.LinkCreateHandler)
-		v1.Get("/link/{id}", s.LinkGetHandler)
-		//v1.Put("/link/{id}", s.LinkCreateManualHandler)
-		v1.Delete("/link/{id}", s.LinkDeleteHandler)
-
-		v1.Get("/text", s.TextListHandler)
-		v1.Post("/text", s.TextCreateHandler)
-		v1.Get("/text/{id}", s.TextGetHandler)
-		//v1.Put("/text/{id}", s.TextCreateManualHandler)
-		v1.Delete("/text/{id}", s.TextDeleteHandler)
+		admin.Get("/_contents", s.FileContentsListHandler)
+		admin.Get("/_contents/{fid}", s.FileContentsGetHandler)
+		admin.Delete("/_contents/{fid}", s.FileContentsDeleteHandler)
+
+		// bulk data movement between instances or storage backends
+		admin.Get("/_export", s.ExportHandler)
+		admin.Post("/_import", s.ImportHandler)
+
+		// rebuilds the search index from the stored items
+		admin.Post("/_reindex", s.ReindexHandler)
 	})
 }
 
+// routes which are scoped to a channel
+func (s *Server) routeItems(r chi.Router) {
+	read := r.With(s.require(ScopeRead))
+	create := r.With(s.require(ScopeCreate))
+	del := r.With(s.require(ScopeDelete))
+	admin := r.With(s.require(ScopeAdmin))
+
+	read.Get("/file", s.FileGroupListHandler)
+	create.Post("/file", s.FileGroupCreateHandler)
+	create.Post("/file/{id}", s.FileUploadHandler)
+	read.Get("/file/{id}", s.FileGroupGetHandler)
+	create.Put("/file/{filename}", s.FileCreateManualHandler)
+	del.Delete("/file/{id}", s.FileGroupDeleteHandler)
+	create.Post("/file/{id}/touch", s.FileGroupTouchHandler)
+	read.Get("/file/{id}/qr", s.FileGroupQRHandler)
+	read.Get("/file/{gid}/{fid}", s.FileContentsGetHandler)
+
+	read.Get("/link", s.LinkListHandler)
+	create.Post("/link", s.LinkCreateHandler)
+	read.Get("/link/{id}", s.LinkGetHandler)
+	//create.Put("/link/{id}", s.LinkCreateManualHandler)
+	del.Delete("/link/{id}", s.LinkDeleteHandler)
+	create.Post("/link/{id}/touch", s.LinkTouchHandler)
+	read.Get("/link/{id}/qr", s.LinkQRHandler)
+
+	read.Get("/text", s.TextListHandler)
+	create.Post("/text", s.TextCreateHandler)
+	read.Get("/text/{id}", s.TextGetHandler)
+	//create.Put("/text/{id}", s.TextCreateManualHandler)
+	del.Delete("/text/{id}", s.TextDeleteHandler)
+	create.Post("/text/{id}/touch", s.TextTouchHandler)
+	read.Get("/text/{id}/qr", s.TextQRHandler)
+
+	read.Get("/search", s.SearchHandler)
+	read.Get("/tags", s.TagListHandler)
+	read.Get("/events", s.EventsHandler)
+
+	read.Get("/trash", s.TrashListHandler)
+	admin.Delete("/trash", s.TrashEmptyHandler)
+	del.Post("/trash/{type}/{id}/restore", s.TrashRestoreHandler)
+	del.Delete("/trash/{type}/{id}", s.TrashPurgeHandler)
+}
+
 func contentJSON(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
@@ -75,8 +112,12 @@ func contentJSON(next http.Handler) http.Handler {
 	})
 }
 
-func cors(next http.Handler) http.Handler {
+const corsAllowHeaders = "Origin, X-Requested-With, Content-Type, Accept, Authorization, User, Content-Length, Accept-Encoding, X-CSRF-Token, " + OwnerHeader + ", " + PasswordHeader + ", " + RevealHeader
+
+func (s *Server) cors(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := s.allowedOrigin(r.Header.Get("Origin"))
+
 		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 			// OPTIONS -- let's handle fully in here
 
@@ -84,9 +125,11 @@ func cors(next http.Handler) http.Handler {
 			w.Header().Add("Vary", "Access-Control-Request-Method")
 			w.Header().Add("Vary", "Access-Control-Request-Headers")
 
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", strings.ToUpper(r.Header.Get("Access-Control-Request-Method")))
-			w.Header().Set("Access-Control-Allow-Headers", "Origin, X-Requested-With, Content-Type, Accept, User, Content-Length, Accept-Encoding, X-CSRF-Token")
+			if origin != "" {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Methods", strings.ToUpper(r.Header.Get("Access-Control-Request-Method")))
+				w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			}
 
 			w.WriteHeader(http.StatusOK)
 			return
@@ -94,9 +137,25 @@ func cors(next http.Handler) http.Handler {
 		}
 		// not OPTIONS, do some header alteration and pass on
 		w.Header().Add("Vary", "Origin")
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Headers", "Origin, X-Requested-With, Content-Type, Accept, User, Content-Length, Accept-Encoding, X-CSRF-Token")
+		if origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			w.Header().Set("Access-Control-Expose-Headers", OwnerHeader)
+		}
 		next.ServeHTTP(w, r)
 
 	})
 }
+
+// the Access-Control-Allow-Origin value for a request's origin. Empty when not allowed
+func (s *Server) allowedOrigin(origin string) string {
+	for _, o := range s.CORSOrigins {
+		if o == "*" {
+			return "*"
+		}
+		if origin != "" && strings.EqualFold(o, origin) {
+			return origin
+		}
+	}
+	return ""
+}
@@ -0,0 +1,387 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"strings"
+	"unicode"
+
+	badger "github.com/dgraph-io/badger/v2"
+)
+
+// The search index is kept per channel, with a key for every term of every
+// indexed item:  <index prefix> <term> \0 <type> <id>  holding how often the
+// term occurs. Entries are written along with their item, and expire with it.
+// Removing or changing an item removes the entries it was indexed with, in
+// the same transaction, so searches only ever read the index
+
+const (
+	minTermLen  = 2
+	maxTermLen  = 64
+	maxDocTerms = 5000 // distinct terms indexed per item. The rest are left out
+	maxResults  = 50
+	snippetLen  = 160
+	snippetLead = 40 // characters shown before the first match
+	exactWeight = 2  // a query term matching a whole term. Prefixes count 1
+	ellipsis    = "…"
+)
+
+// SearchResult is an item matching a query
+type SearchResult struct {
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+	Score   int    `json:"score"`
+	Snippet string `json:"snippet"`
+	Created int64  `json:"created,omitempty"`
+
+	matched int // distinct query terms found
+}
+
+// splits text into lowercase terms, counting each
+func tokenize(s string) map[string]int {
+	terms := map[string]int{}
+	for _, t := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		if len(t) < minTermLen || len(t) > maxTermLen {
+			continue
+		}
+		if _, seen := terms[t]; !seen && len(terms) >= maxDocTerms {
+			continue
+		}
+		terms[t]++
+	}
+	return terms
+}
+
+// the text of an item which is searched. Items which must not turn up in
+// searches, or can't be read by the server, are not indexable
+func searchText(rec record, u UMField) (string, bool) {
+	if u.Has(Hidden) || u.Has(BurnAfterRead) || u.Has(Locked) || u.Has(Encrypted) {
+		return "", false
+	}
+	switch r := rec.(type) {
+	case *Text:
+		return r.Text, true
+	case *Link:
+		return r.URL, true
+	case *FileGroup:
+		names := make([]string, len(r.Files))
+		for i, f := range r.Files {
+			names[i] = f.FileName
+		}
+		return strings.Join(names, ", "), true
+	}
+	return "", false
+}
+
+func indexPrefix(ch string) []byte {
+	return typePrefix(ch, StorageIndexKey)
+}
+
+func postingKey(ch string, term string, sk StorageKey, id string) []byte {
+	key := append(indexPrefix(ch), term...)
+	key = append(key, 0, byte(sk))
+	return append(key, id...)
+}
+
+// the search and tag index entries for an item, from its stored JSON
+func indexEntries(ch string, sk StorageKey, id string, data []byte, u UMField, expiresAt uint64) ([]*badger.Entry, error) {
+	rec, ok := newRecord(sk)
+	if !ok {
+		return nil, nil
+	}
+	if err := jsCfg.Unmarshal(data, rec); err != nil {
+		return nil, err
+	}
+	return append(postings(ch, sk, id, rec, u, expiresAt), tagEntries(ch, sk, id, rec, u, expiresAt)...), nil
+}
+
+// the search index entries for an item
+func postings(ch string, sk StorageKey, id string, rec record, u UMField, expiresAt uint64) []*badger.Entry {
+	text, ok := searchText(rec, u)
+	if !ok {
+		return nil
+	}
+
+	terms := tokenize(text)
+	entries := make([]*badger.Entry, 0, len(terms))
+	for term, n := range terms {
+		buf := make([]byte, binary.MaxVarintLen64)
+		buf = buf[:binary.PutUvarint(buf, uint64(n))]
+		entry := badger.NewEntry(postingKey(ch, term, sk, id), buf)
+		entry.ExpiresAt = expiresAt
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// writes an item's search and tag index entries, within the transaction
+// storing it. Renewals write them again, with the new expiration
+func indexTx(tx *badger.Txn, ch string, sk StorageKey, id string, data []byte, u UMField, expiresAt uint64) error {
+	entries, err := indexEntries(ch, sk, id, data, u, expiresAt)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := tx.SetEntry(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removes the search and tag index entries of an item as it is stored,
+// within the transaction removing or changing it
+func unindexTx(tx *badger.Txn, ch string, sk StorageKey, id string, item *badger.Item) error {
+	if _, ok := newRecord(sk); !ok {
+		return nil
+	}
+	data, err := item.ValueCopy(nil)
+	if err != nil {
+		return err
+	}
+	entries, err := indexEntries(ch, sk, id, data, UMField(item.UserMeta()), 0)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := tx.Delete(e.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finds items in a channel matching any of the query's terms, as whole terms
+// or as prefixes. Items matching more of the terms rank first, then those
+// where they occur more often, then newer ones
+func search(db *badger.DB, ch string, query string) ([]SearchResult, error) {
+	qterms := tokenize(query)
+	if len(qterms) == 0 {
+		return []SearchResult{}, nil
+	}
+
+	hits := map[string]*SearchResult{} // by type byte and ID
+	pfx := indexPrefix(ch)
+	err := db.View(func(tx *badger.Txn) error {
+		for term := range qterms {
+			termPfx := append(append([]byte{}, pfx...), term...)
+			seen := map[string]bool{}
+			opts := badger.DefaultIteratorOptions
+			opts.Prefix = termPfx
+			it := tx.NewIterator(opts)
+			for it.Seek(termPfx); it.ValidForPrefix(termPfx); it.Next() {
+				key := it.Item().KeyCopy(nil)
+				sep := bytes.IndexByte(key[len(pfx):], 0)
+				if sep < 0 || len(key) < len(pfx)+sep+3 {
+					continue
+				}
+				doc := string(key[len(pfx)+sep+1:])
+
+				weight := 1
+				if sep == len(term) {
+					weight = exactWeight
+				}
+				var n uint64
+				if err := it.Item().Value(func(v []byte) error {
+					n, _ = binary.Uvarint(v)
+					return nil
+				}); err != nil {
+					it.Close()
+					return err
+				}
+
+				h, ok := hits[doc]
+				if !ok {
+					h = &SearchResult{
+						Type: typeNames[StorageKey(doc[0])],
+						ID:   doc[1:],
+					}
+					hits[doc] = h
+				}
+				if !seen[doc] {
+					seen[doc] = true
+					h.matched++
+				}
+				h.Score += weight * int(n)
+			}
+			it.Close()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0, len(hits))
+	err = db.View(func(tx *badger.Txn) error {
+		for doc, h := range hits {
+			sk := StorageKey(doc[0])
+			item, err := tx.Get(makeKey(ch, sk, h.ID))
+			if err == badger.ErrKeyNotFound {
+				continue // expired between the two reads
+			}
+			if err != nil {
+				return err
+			}
+			rec, ok := newRecord(sk)
+			if !ok {
+				continue
+			}
+			if err := item.Value(func(v []byte) error {
+				return jsCfg.Unmarshal(v, rec)
+			}); err != nil {
+				return err
+			}
+			text, ok := searchText(rec, UMField(item.UserMeta()))
+			if !ok {
+				continue
+			}
+			h.Snippet = snippet(text, qterms)
+			h.Created = rec.common().Created
+			results = append(results, *h)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.matched != b.matched {
+			return a.matched > b.matched
+		}
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.Created > b.Created
+	})
+	if len(results) > maxResults {
+		results = results[:maxResults]
+	}
+	return results, nil
+}
+
+// a short excerpt of text around the first place a query term occurs, with
+// whitespace collapsed
+func snippet(text string, qterms map[string]int) string {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+	lower := []rune(strings.ToLower(string(runes)))
+	if len(lower) != len(runes) {
+		lower = runes // lowercasing changed the length. Match from the start
+	}
+
+	first := -1
+	for term := range qterms {
+		if i := runeIndex(lower, []rune(term)); i >= 0 && (first < 0 || i < first) {
+			first = i
+		}
+	}
+
+	start := 0
+	if first > snippetLead {
+		start = first - snippetLead
+		// begin at a word, when there's one before the match
+		for i := start; i < first; i++ {
+			if runes[i] == ' ' {
+				start = i + 1
+				break
+			}
+		}
+	}
+	end := start + snippetLen
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	s := string(runes[start:end])
+	if start > 0 {
+		s = ellipsis + s
+	}
+	if end < len(runes) {
+		s += ellipsis
+	}
+	return s
+}
+
+func runeIndex(s []rune, sub []rune) int {
+	for i := 0; i+len(sub) <= len(s); i++ {
+		match := true
+		for j := range sub {
+			if s[i+j] != sub[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
+
+// RebuildIndex drops the whole search and tag indexes, and indexes every
+// item again. Returns the number of items indexed
+func RebuildIndex(db *badger.DB) (int, error) {
+	prefixes := [][]byte{indexPrefix(""), tagPrefix("")}
+	err := db.View(func(tx *badger.Txn) error {
+		// channels with nothing but stale index entries left are included
+		return eachItem(tx, []byte{byte(StorageChannelKey)}, false, func(ch string, sk StorageKey, _ string, _ *badger.Item) error {
+			if sk == StorageIndexKey || sk == StorageTagKey {
+				pfx := typePrefix(ch, sk)
+				if !bytes.Equal(prefixes[len(prefixes)-1], pfx) {
+					prefixes = append(prefixes, pfx)
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := db.DropPrefix(prefixes...); err != nil {
+		return 0, err
+	}
+
+	wb := db.NewWriteBatch()
+	defer wb.Cancel()
+	total := 0
+	err = db.View(func(tx *badger.Txn) error {
+		index := func(ch string, sk StorageKey, id string, item *badger.Item) error {
+			if _, ok := newRecord(sk); !ok {
+				return nil
+			}
+			data, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			entries, err := indexEntries(ch, sk, id, data, UMField(item.UserMeta()), item.ExpiresAt())
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				if err := wb.SetEntry(e); err != nil {
+					return err
+				}
+			}
+			if len(entries) > 0 {
+				total++
+			}
+			return nil
+		}
+
+		for _, sk := range []StorageKey{StorageTextKey, StorageLinkKey, StorageFileGroupKey} {
+			if err := eachItem(tx, typePrefix("", sk), true, index); err != nil {
+				return err
+			}
+		}
+		return eachItem(tx, []byte{byte(StorageChannelKey)}, true, index)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return total, wb.Flush()
+}
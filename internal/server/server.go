@@ -2,10 +2,16 @@ package server
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	badger "github.com/dgraph-io/badger/v2"
@@ -14,14 +20,121 @@ import (
 )
 
 type Server struct {
-	Log          *logrus.Logger
-	Router       *chi.Mux
-	AssetHandler StaticHandler
-	DB           *badger.DB
-	Http         *http.Server
+	Log            *logrus.Logger
+	Router         *chi.Mux
+	AssetHandler   StaticHandler
+	DB             *badger.DB
+	Http           *http.Server
+	TrashRetention time.Duration // how long deleted items may be restored. 0 deletes immediately
+	Tokens         []Token       // API tokens allowed access. Auth is disabled when empty
+	CORSOrigins    []string      // origins allowed cross-origin requests. "*" for any
+	DefaultTTL     time.Duration // TTL of items created without one. 0 never expires
+	MaxTTL         time.Duration // longest TTL allowed on creation. 0 for no limit
+	Redirect       *http.Server  // redirects plain HTTP to HTTPS, when serving TLS
+	Listen         []string      // addresses to listen on, instead of the port given to New
+	BaseURL        *url.URL      // public URL, or only the path, the server is reached at. nil for the root of each request's host
+	TrustedProxies []*net.IPNet  // proxies whose X-Forwarded-* headers are believed. nil matches unix sockets
+	InlineTypes    []string      // content types of files shown in the browser. Others are downloaded
+	MaxUpload      int64         // most bytes one upload request may send. 0 for no limit
+
+	httpsPort    int    // TCP port HTTPS is served on, for redirects. 0 when not known
+	revealSecret []byte // signs reveal tokens for burn-after-read items
+	events       *eventHub
+	uploads      sync.Mutex // serializes adding files to groups
+}
+
+// Option sets optional behavior on a Server
+type Option func(*Server)
+
+// WithTrash keeps deleted items in a trash bin, restorable for the given duration
+func WithTrash(retention time.Duration) Option {
+	return func(s *Server) {
+		s.TrashRetention = retention
+	}
+}
+
+// WithTokens enables authentication, allowing requests only with one of the given API tokens
+func WithTokens(tokens ...Token) Option {
+	return func(s *Server) {
+		s.Tokens = append(s.Tokens, tokens...)
+	}
+}
+
+// WithTTL sets the TTL given to items created without one, and the longest
+// allowed. With only a maximum, it is also the default, as items may no
+// longer be kept forever
+func WithTTL(def time.Duration, max time.Duration) Option {
+	return func(s *Server) {
+		if def == 0 {
+			def = max
+		}
+		s.DefaultTTL = def
+		s.MaxTTL = max
+	}
+}
+
+// WithListen listens on the given addresses instead of the port given to New.
+// These may be host:port, unix:/path/to.sock, systemd for every socket passed
+// by systemd socket activation, or systemd:name for one of them
+func WithListen(addrs ...string) Option {
+	return func(s *Server) {
+		s.Listen = append(s.Listen, addrs...)
+	}
+}
+
+// WithTLS serves HTTPS with the given configuration, which must hold a certificate
+func WithTLS(cfg *tls.Config) Option {
+	return func(s *Server) {
+		s.Http.TLSConfig = cfg
+	}
+}
+
+// WithRedirect listens for plain HTTP on the given port, redirecting every
+// request to HTTPS. Only used along with TLS
+func WithRedirect(port int) Option {
+	return func(s *Server) {
+		s.Redirect = &http.Server{
+			Addr:              ":" + strconv.Itoa(port),
+			ReadHeaderTimeout: 10 * time.Second,
+			MaxHeaderBytes:    1 << 16,
+			Handler:           http.HandlerFunc(s.redirectTLS),
+		}
+	}
+}
+
+// WithBaseURL sets the public URL the server is reached at, as made by
+// ParseBaseURL. Generated URLs start with it, and the server is mounted
+// under its path
+func WithBaseURL(u *url.URL) Option {
+	return func(s *Server) {
+		s.BaseURL = u
+	}
+}
+
+// WithTrustedProxies believes the X-Forwarded-* headers of requests from the
+// given addresses, as made by ParseTrustedProxies, and only from them
+func WithTrustedProxies(nets ...*net.IPNet) Option {
+	return func(s *Server) {
+		s.TrustedProxies = append(s.TrustedProxies, nets...)
+	}
+}
+
+// WithMaxUpload sets the most bytes one request may upload, replacing
+// DefaultMaxUpload. 0 for no limit
+func WithMaxUpload(n int64) Option {
+	return func(s *Server) {
+		s.MaxUpload = n
+	}
+}
+
+// WithCORS sets the origins allowed to make cross-origin requests
+func WithCORS(origins ...string) Option {
+	return func(s *Server) {
+		s.CORSOrigins = origins
+	}
 }
 
-func New(log *logrus.Logger, port int, sh StaticHandler, db *badger.DB) (*Server, error) {
+func New(log *logrus.Logger, port int, sh StaticHandler, db *badger.DB, opts ...Option) (*Server, error) {
 
 	if db == nil {
 		return nil, errors.New("No valid database provided")
@@ -29,44 +142,79 @@ func New(log *logrus.Logger, port int, sh StaticHandler, db *badger.DB) (*Server
 
 	router := chi.NewRouter()
 
+	revealSecret := make([]byte, 32)
+	if _, err := rand.Read(revealSecret); err != nil {
+		return nil, err
+	}
+
 	s := &Server{
 		Log:          log,
 		Router:       router,
 		AssetHandler: sh,
 		DB:           db,
+		CORSOrigins:  []string{"*"},
+		InlineTypes:  DefaultInlineTypes,
+		MaxUpload:    DefaultMaxUpload,
+		revealSecret: revealSecret,
+		events:       newEventHub(),
 		Http: &http.Server{
 			Addr:           ":" + strconv.Itoa(port),
 			ReadTimeout:    30 * time.Second,
 			WriteTimeout:   60 * time.Second,
 			IdleTimeout:    300 * time.Second,
 			MaxHeaderBytes: 1 << 16,
-			// TLSConfig: tlsConfig,
-			Handler: router,
 		},
 	}
 
+	for _, opt := range opts {
+		opt(s)
+	}
+
 	s.SetupRoutes()
+	s.Http.Handler = s.proxyHeaders(s.mount(router))
 
 	return s, nil
 }
 
 func (s *Server) Start(ctx context.Context) error {
-	errs := make(chan error)
-
-	tps := []string{"tcp4", "tcp6"}
-	for _, l := range tps {
-		s.Log.WithField("transport", l).WithField("addr", s.Http.Addr).Debug("opening socket")
-		n, err := net.Listen(l, s.Http.Addr)
-		if err != nil {
+	// every listener is opened before any is served, so a failure leaves
+	// nothing behind
+	ls, err := openListeners(s.Listen, s.Http.Addr)
+	if err != nil {
+		return err
+	}
+	useTLS := s.Http.TLSConfig != nil
+	var rls []listener
+	if useTLS && s.Redirect != nil {
+		if rls, err = openListeners(nil, s.Redirect.Addr); err != nil {
+			for _, l := range ls {
+				l.Close()
+			}
 			return err
 		}
-		go func() {
-			errs <- s.Http.Serve(n)
-		}()
 	}
-	s.Log.Info("listening on -> " + s.Http.Addr)
 
-	var err error
+	// room for every listener to report, as only the first is waited for
+	errs := make(chan error, len(ls)+len(rls))
+	for _, l := range ls {
+		if addr, ok := l.Addr().(*net.TCPAddr); ok && s.httpsPort == 0 {
+			s.httpsPort = addr.Port
+		}
+	}
+	s.serve(ls, errs, func(n net.Listener) error {
+		if useTLS {
+			return s.Http.ServeTLS(n, "", "")
+		}
+		return s.Http.Serve(n)
+	})
+	for _, l := range ls {
+		s.Log.WithField("tls", useTLS).Info("listening on -> " + l.addr)
+	}
+	if len(rls) > 0 {
+		s.serve(rls, errs, s.Redirect.Serve)
+		s.Log.Info("redirecting to HTTPS from -> " + s.Redirect.Addr)
+	}
+
 	select {
 	case err = <-errs:
 	case <-ctx.Done():
@@ -75,8 +223,50 @@ func (s *Server) Start(ctx context.Context) error {
 	return err
 }
 
+// serves each listener in the background. Failures are sent on errs, naming
+// the listener they came from
+func (s *Server) serve(ls []listener, errs chan<- error, serve func(net.Listener) error) {
+	for _, l := range ls {
+		l := l
+		go func() {
+			err := serve(l)
+			if err != nil && err != http.ErrServerClosed {
+				s.Log.WithError(err).WithField("addr", l.addr).Error("listener failed")
+				err = fmt.Errorf("%s: %w", l.addr, err)
+			}
+			errs <- err
+		}()
+	}
+}
+
+// sends plain HTTP requests to the same place over HTTPS
+func (s *Server) redirectTLS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(r.Host); err == nil {
+		host = h
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]" // IPv6
+	}
+	if s.httpsPort != 0 && s.httpsPort != 443 {
+		host += ":" + strconv.Itoa(s.httpsPort)
+	}
+	u := *r.URL
+	u.Scheme = "https"
+	u.Host = host
+	if s.BaseURL != nil && s.BaseURL.Scheme == "https" {
+		u.Host = s.BaseURL.Host
+	}
+	http.Redirect(w, r, u.String(), http.StatusPermanentRedirect)
+}
+
 func (s *Server) Shutdown() error {
 	s.Log.Info("gracefully shutting down server")
-	ctx, _ := context.WithTimeout(context.Background(), 2*time.Second) // nolint not bothering to cancel since we're near process exit
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if s.Redirect != nil {
+		s.Redirect.Shutdown(ctx)
+	}
+	s.events.close()
 	return s.Http.Shutdown(ctx)
 }
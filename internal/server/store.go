@@ -1,6 +1,8 @@
 package server
 
 import (
+	"bytes"
+	"fmt"
 	"time"
 
 	badger "github.com/dgraph-io/badger/v2"
@@ -14,8 +16,58 @@ const (
 	StorageFileKey      StorageKey = 'f'
 	StorageTextKey      StorageKey = 't'
 	StorageLinkKey      StorageKey = 'l'
+	StorageTrashKey     StorageKey = 'x'
+	StorageChannelKey   StorageKey = 'c'
+	StorageOwnerKey     StorageKey = 'o'
+	StoragePasswordKey  StorageKey = 'p'
+	StorageIndexKey     StorageKey = 'i' // search index entries
+	StorageTagKey       StorageKey = 'k' // tag index entries
 )
 
+// keys stored alongside an item, following it wherever it goes. They are
+// keyed by their own prefix, followed by the key of the item they belong to
+var auxKeys = []StorageKey{StorageOwnerKey, StoragePasswordKey}
+
+func auxKey(aux StorageKey, itemKey []byte) []byte {
+	return append([]byte{byte(aux)}, itemKey...)
+}
+
+// item types, as named in routes and exports
+var typeNames = map[StorageKey]string{
+	StorageTextKey:      "text",
+	StorageLinkKey:      "link",
+	StorageFileGroupKey: "file",
+	StorageFileKey:      "blob",
+}
+
+// record is the Go type of a stored item, for decoding its JSON into
+type record interface {
+	common() *CommonFields
+}
+
+func (c *CommonFields) common() *CommonFields { return c }
+
+func newRecord(sk StorageKey) (record, bool) {
+	switch sk {
+	case StorageTextKey:
+		return &Text{}, true
+	case StorageLinkKey:
+		return &Link{}, true
+	case StorageFileGroupKey:
+		return &FileGroup{}, true
+	}
+	return nil, false
+}
+
+func typeByName(t string) (StorageKey, bool) {
+	for sk, name := range typeNames {
+		if name == t {
+			return sk, true
+		}
+	}
+	return 0, false
+}
+
 var jsCfg = jsoniter.Config{
 	EscapeHTML:                    true,
 	SortMapKeys:                   false,
@@ -31,6 +83,8 @@ type UMField byte
 const (
 	BurnAfterRead UMField = 1 << iota
 	Hidden
+	Locked    // password protected
+	Encrypted // contents were encrypted by the client. The server can't read them
 	// ...
 )
 
@@ -39,10 +93,10 @@ func (u UMField) Clear(flag UMField) UMField  { return u &^ flag }
 func (u UMField) Toggle(flag UMField) UMField { return u ^ flag }
 func (u UMField) Has(flag UMField) bool       { return u&flag != 0 }
 
-func getAllForType(db *badger.DB, sk StorageKey) ([][]byte, error) {
+func getAllForType(db *badger.DB, ch string, sk StorageKey) ([][]byte, error) {
 	total := make([][]byte, 0, 20)
 
-	pfx := []byte{byte(sk)}
+	pfx := typePrefix(ch, sk)
 	opts := badger.DefaultIteratorOptions
 	opts.PrefetchValues = true
 	opts.Prefix = pfx
@@ -50,28 +104,13 @@ func getAllForType(db *badger.DB, sk StorageKey) ([][]byte, error) {
 		it := tx.NewIterator(opts)
 		defer it.Close()
 		for it.Seek(pfx); it.ValidForPrefix(pfx); it.Next() {
-			u := UMField(it.Item().UserMeta())
-			if u.Has(Hidden) {
-				continue
-			}
-
-			if err := it.Item().Value(func(v []byte) error {
-				var buf []byte
-				var err error
-				if u.Has(BurnAfterRead) {
-					buf, err = censorPreventBurn(sk, v)
-					if err != nil {
-						return err
-					}
-				} else {
-					buf = make([]byte, len(v))
-					copy(buf, v)
-				}
-				total = append(total, buf)
-				return nil
-			}); err != nil {
+			buf, ok, err := listValue(sk, it.Item())
+			if err != nil {
 				return err
 			}
+			if ok {
+				total = append(total, buf)
+			}
 		}
 		return nil
 	})
@@ -79,24 +118,61 @@ func getAllForType(db *badger.DB, sk StorageKey) ([][]byte, error) {
 	return total, err
 }
 
+// an item as it is shown in listings. Hidden items are left out, returning
+// false, and those which can't be shown there are censored
+func listValue(sk StorageKey, item *badger.Item) ([]byte, bool, error) {
+	u := UMField(item.UserMeta())
+	if u.Has(Hidden) {
+		return nil, false, nil
+	}
+
+	var buf []byte
+	if err := item.Value(func(v []byte) error {
+		var err error
+		if u.Has(BurnAfterRead) || u.Has(Locked) {
+			buf, err = censorContents(sk, v)
+			if err != nil {
+				return err
+			}
+		} else {
+			buf = make([]byte, len(v))
+			copy(buf, v)
+		}
+		buf, err = withExpiry(sk, buf, item.ExpiresAt())
+		return err
+	}); err != nil {
+		return nil, false, err
+	}
+	return buf, true, nil
+}
+
 func makeMeta(c CommonFields) UMField {
 	u := UMField(0)
 	if c.BurnAfterRead {
 		u = u.Set(BurnAfterRead)
 	}
+	if c.Hidden {
+		u = u.Set(Hidden)
+	}
+	if c.Locked {
+		u = u.Set(Locked)
+	}
+	if c.Encrypted {
+		u = u.Set(Encrypted)
+	}
 	return u
 }
 
-func writeType(db *badger.DB, sk StorageKey, id string, item interface{}, u UMField, ttl int64) error {
+func writeType(db *badger.DB, ch string, sk StorageKey, id string, item interface{}, u UMField, ttl int64) error {
 	buf, err := jsCfg.Marshal(item)
 	if err != nil {
 		return err
 	}
-	return writeBytes(db, sk, id, buf, u, ttl)
+	return writeBytes(db, ch, sk, id, buf, u, ttl)
 }
 
-func writeBytes(db *badger.DB, sk StorageKey, id string, buf []byte, u UMField, ttl int64) error {
-	key := makeKey(sk, id)
+func writeBytes(db *badger.DB, ch string, sk StorageKey, id string, buf []byte, u UMField, ttl int64) error {
+	key := makeKey(ch, sk, id)
 	entry := badger.NewEntry(key, buf).WithMeta(byte(u))
 	if ttl > 0 {
 		entry = entry.WithTTL(time.Duration(ttl) * time.Second)
@@ -106,19 +182,38 @@ func writeBytes(db *badger.DB, sk StorageKey, id string, buf []byte, u UMField,
 	})
 }
 
+// stores a new record along with its search and tag index entries, so it is
+// never stored without them
+func writeIndexed(db *badger.DB, ch string, sk StorageKey, id string, buf []byte, u UMField, ttl int64) error {
+	expires := ttlExpiry(ttl)
+	entry := badger.NewEntry(makeKey(ch, sk, id), buf).WithMeta(byte(u))
+	entry.ExpiresAt = expires
+	return db.Update(func(tx *badger.Txn) error {
+		if err := tx.SetEntry(entry); err != nil {
+			return err
+		}
+		return indexTx(tx, ch, sk, id, buf, u, expires)
+	})
+}
+
 type FetchOpts struct {
-	SkipBurn bool // does not burn item on read
+	SkipBurn bool // does not burn item on read, or count it as a view
 }
 
 var DontBurn = &FetchOpts{SkipBurn: true}
 
-func _getOne(db *badger.DB, f *FetchOpts, sk StorageKey, id string, cb func([]byte) error) error {
-	key := makeKey(sk, id)
+func _getOne(db *badger.DB, f *FetchOpts, ch string, sk StorageKey, id string, cb func([]byte) error) error {
+	key := makeKey(ch, sk, id)
 
 	if f == nil {
 		f = &FetchOpts{}
 	}
 
+	// file contents are counted against their group, through viewFile
+	if !f.SkipBurn && sk != StorageFileKey {
+		return viewRecord(db, ch, sk, id, cb)
+	}
+
 	err := db.View(func(tx *badger.Txn) error {
 		item, err := tx.Get(key)
 		if err != nil {
@@ -129,7 +224,7 @@ func _getOne(db *badger.DB, f *FetchOpts, sk StorageKey, id string, cb func([]by
 			return err
 		}
 		if UMField(item.UserMeta()).Has(BurnAfterRead) && !f.SkipBurn {
-			deleteRecord(db, sk, id)
+			deleteRecord(db, ch, sk, id)
 		}
 		return nil
 	})
@@ -137,9 +232,243 @@ func _getOne(db *badger.DB, f *FetchOpts, sk StorageKey, id string, cb func([]by
 	return err
 }
 
-func getOneBytes(db *badger.DB, f *FetchOpts, sk StorageKey, id string) ([]byte, error) {
+// reads a record on behalf of a visitor, counting the view. The record is
+// consumed by the read reaching its view limit, or by the first read when
+// burn-after-read. The callback gets the record with this view counted
+func viewRecord(db *badger.DB, ch string, sk StorageKey, id string, cb func([]byte) error) error {
+	key := makeKey(ch, sk, id)
+
+	for attempt := 1; ; attempt++ {
+		var val []byte
+		var expires uint64
+		var renewed []File // file contents to renew with a sliding group
+		err := db.Update(func(tx *badger.Txn) error {
+			item, err := tx.Get(key)
+			if err != nil {
+				return err
+			}
+			rec, ok := newRecord(sk)
+			if !ok {
+				return fmt.Errorf("unknown item type %q", sk)
+			}
+			if err := item.Value(func(v []byte) error {
+				return jsCfg.Unmarshal(v, rec)
+			}); err != nil {
+				return err
+			}
+
+			c := rec.common()
+			c.Views++
+			c.Accessed = time.Now().Unix()
+			if val, err = jsCfg.Marshal(rec); err != nil {
+				return err
+			}
+
+			if UMField(item.UserMeta()).Has(BurnAfterRead) || (c.MaxViews > 0 && c.Views >= c.MaxViews) {
+				return consumeRecord(tx, ch, sk, id, item, rec)
+			}
+			expires = item.ExpiresAt()
+			sliding := c.Sliding && c.TTL > 0
+			if sliding {
+				expires = ttlExpiry(c.TTL)
+				if err := renewAux(tx, key, expires); err != nil {
+					return err
+				}
+				if err := indexTx(tx, ch, sk, id, val, UMField(item.UserMeta()), expires); err != nil {
+					return err
+				}
+				if fg, ok := rec.(*FileGroup); ok {
+					renewed = fg.Files
+				}
+			}
+			entry := badger.NewEntry(key, val).WithMeta(item.UserMeta())
+			entry.ExpiresAt = expires
+			if err := tx.SetEntry(entry); err != nil {
+				return err
+			}
+
+			// only the response gets the expiration. It's already kept by the entry
+			if setExpiry(c, expires) {
+				val, err = jsCfg.Marshal(rec)
+			}
+			return err
+		})
+		// concurrent views of the same item. Only one may take the last view
+		if err == badger.ErrConflict && attempt < 10 {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := renewFiles(db, renewed, expires); err != nil {
+			return err
+		}
+		return cb(val)
+	}
+}
+
+// reads a record without counting it as a view, with its expiration filled in
+func peekRecord(db *badger.DB, ch string, sk StorageKey, id string) ([]byte, error) {
+	var buf []byte
+	err := db.View(func(tx *badger.Txn) error {
+		item, err := tx.Get(makeKey(ch, sk, id))
+		if err != nil {
+			return err
+		}
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		buf, err = withExpiry(sk, v, item.ExpiresAt())
+		return err
+	})
+	return buf, err
+}
+
+// reads a file's contents on behalf of a visitor, counting the view against
+// its group, as the group's listing isn't counted. A group reaching its view
+// limit is consumed along with all its files. Burn-after-read groups instead
+// lose each file as it is read, and go with their last one
+func viewFile(db *badger.DB, ch string, gid string, fid string, cb func([]byte) error) error {
+	key := makeKey(ch, StorageFileGroupKey, gid)
+	blobKey := makeKey("", StorageFileKey, fid)
+
+	for attempt := 1; ; attempt++ {
+		var contents []byte
+		var expires uint64
+		var renewed []File // file contents to renew with a sliding group
+		err := db.Update(func(tx *badger.Txn) error {
+			item, err := tx.Get(key)
+			if err != nil {
+				return err
+			}
+			var fg FileGroup
+			if err := item.Value(func(v []byte) error {
+				return jsCfg.Unmarshal(v, &fg)
+			}); err != nil {
+				return err
+			}
+			i := 0
+			for i < len(fg.Files) && fg.Files[i].ID != fid {
+				i++
+			}
+			if i == len(fg.Files) {
+				return badger.ErrKeyNotFound // not one of this group's
+			}
+			blob, err := tx.Get(blobKey)
+			if err != nil {
+				return err
+			}
+			if contents, err = blob.ValueCopy(nil); err != nil {
+				return err
+			}
+
+			fg.Views++
+			fg.Accessed = time.Now().Unix()
+			if fg.MaxViews > 0 && fg.Views >= fg.MaxViews {
+				return consumeRecord(tx, ch, StorageFileGroupKey, gid, item, &fg)
+			}
+			burned := UMField(item.UserMeta()).Has(BurnAfterRead)
+			if burned {
+				fg.Files = append(fg.Files[:i:i], fg.Files[i+1:]...)
+				if err := tx.Delete(blobKey); err != nil {
+					return err
+				}
+				if len(fg.Files) == 0 {
+					return consumeRecord(tx, ch, StorageFileGroupKey, gid, item, &fg)
+				}
+			}
+
+			expires = item.ExpiresAt()
+			sliding := fg.Sliding && fg.TTL > 0
+			if sliding {
+				expires = ttlExpiry(fg.TTL)
+				if err := renewAux(tx, key, expires); err != nil {
+					return err
+				}
+				renewed = fg.Files
+			}
+			val, err := jsCfg.Marshal(fg)
+			if err != nil {
+				return err
+			}
+			// the file burned takes its name out of the index
+			if burned || sliding {
+				if err := unindexTx(tx, ch, StorageFileGroupKey, gid, item); err != nil {
+					return err
+				}
+				if err := indexTx(tx, ch, StorageFileGroupKey, gid, val, UMField(item.UserMeta()), expires); err != nil {
+					return err
+				}
+			}
+			entry := badger.NewEntry(key, val).WithMeta(item.UserMeta())
+			entry.ExpiresAt = expires
+			return tx.SetEntry(entry)
+		})
+		// concurrent views of the same group. Only one may take the last view
+		if err == badger.ErrConflict && attempt < 10 {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := renewFiles(db, renewed, expires); err != nil {
+			return err
+		}
+		return cb(contents)
+	}
+}
+
+// deletes a record consumed by its last view, for good, out of the indexes
+// too. A file group takes its file contents with it
+func consumeRecord(tx *badger.Txn, ch string, sk StorageKey, id string, item *badger.Item, rec record) error {
+	key := makeKey(ch, sk, id)
+	if err := unindexTx(tx, ch, sk, id, item); err != nil {
+		return err
+	}
+	if fg, ok := rec.(*FileGroup); ok {
+		for _, f := range fg.Files {
+			if err := tx.Delete(makeKey("", StorageFileKey, f.ID)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := deleteAux(tx, key); err != nil {
+		return err
+	}
+	return tx.Delete(key)
+}
+
+// fills in the expiration fields of a record from its entry's expiration
+// time. Returns false when the record doesn't expire
+func setExpiry(c *CommonFields, expiresAt uint64) bool {
+	if expiresAt == 0 {
+		return false
+	}
+	c.Expires = int64(expiresAt)
+	c.Remaining = c.Expires - time.Now().Unix()
+	if c.Remaining < 0 {
+		c.Remaining = 0
+	}
+	return true
+}
+
+// adds the expiration fields to a record's stored JSON, for responses
+func withExpiry(sk StorageKey, data []byte, expiresAt uint64) ([]byte, error) {
+	rec, ok := newRecord(sk)
+	if !ok || expiresAt == 0 {
+		return data, nil
+	}
+	if err := jsCfg.Unmarshal(data, rec); err != nil {
+		return nil, err
+	}
+	setExpiry(rec.common(), expiresAt)
+	return jsCfg.Marshal(rec)
+}
+
+func getOneBytes(db *badger.DB, f *FetchOpts, ch string, sk StorageKey, id string) ([]byte, error) {
 	var buf []byte
-	err := _getOne(db, f, sk, id, func(b []byte) error {
+	err := _getOne(db, f, ch, sk, id, func(b []byte) error {
 		buf = make([]byte, len(b))
 		copy(buf, b)
 		return nil
@@ -147,15 +476,15 @@ func getOneBytes(db *badger.DB, f *FetchOpts, sk StorageKey, id string) ([]byte,
 	return buf, err
 }
 
-func getOne(db *badger.DB, f *FetchOpts, sk StorageKey, id string, t interface{}) error {
-	return _getOne(db, f, sk, id, func(b []byte) error {
+func getOne(db *badger.DB, f *FetchOpts, ch string, sk StorageKey, id string, t interface{}) error {
+	return _getOne(db, f, ch, sk, id, func(b []byte) error {
 		return jsCfg.Unmarshal(b, t)
 	})
 }
 
-func getMeta(db *badger.DB, sk StorageKey, id string) (UMField, error) {
+func getMeta(db *badger.DB, ch string, sk StorageKey, id string) (UMField, error) {
 	var u UMField
-	key := makeKey(sk, id)
+	key := makeKey(ch, sk, id)
 	return u, db.View(func(tx *badger.Txn) error {
 		item, err := tx.Get(key)
 		if err != nil {
@@ -166,19 +495,90 @@ func getMeta(db *badger.DB, sk StorageKey, id string) (UMField, error) {
 	})
 }
 
-// deletes a single record
-func deleteRecord(db *badger.DB, sk StorageKey, id string) error {
-	key := makeKey(sk, id)
+// deletes a single record, and anything stored alongside it or indexed for it
+func deleteRecord(db *badger.DB, ch string, sk StorageKey, id string) error {
+	key := makeKey(ch, sk, id)
 	return db.Update(func(tx *badger.Txn) error {
+		if item, err := tx.Get(key); err == nil {
+			if err := unindexTx(tx, ch, sk, id, item); err != nil {
+				return err
+			}
+		} else if err != badger.ErrKeyNotFound {
+			return err
+		}
+		if err := deleteAux(tx, key); err != nil {
+			return err
+		}
 		return tx.Delete(key)
 	})
 }
 
+func deleteAux(tx *badger.Txn, itemKey []byte) error {
+	for _, aux := range auxKeys {
+		if err := tx.Delete(auxKey(aux, itemKey)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moves the keys stored alongside an item to follow it to a new key
+func moveAux(tx *badger.Txn, from []byte, to []byte, expiresAt uint64) error {
+	for _, aux := range auxKeys {
+		item, err := tx.Get(auxKey(aux, from))
+		if err == badger.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		entry := badger.NewEntry(auxKey(aux, to), val).WithMeta(item.UserMeta())
+		entry.ExpiresAt = expiresAt
+		if err := tx.SetEntry(entry); err != nil {
+			return err
+		}
+		if err := tx.Delete(auxKey(aux, from)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // composes the indexing Key
-func makeKey(sk StorageKey, id string) []byte {
-	key := []byte(id)
-	key = append([]byte{byte(sk)}, key...)
-	return key
+func makeKey(ch string, sk StorageKey, id string) []byte {
+	return append(typePrefix(ch, sk), id...)
+}
+
+// composes the prefix shared by all keys of a type within a channel. The
+// default channel ("") is a single type byte, while named channels are
+// kept under their own prefix:  c <channel> \0 <type>
+func typePrefix(ch string, sk StorageKey) []byte {
+	if ch == "" {
+		return []byte{byte(sk)}
+	}
+	pfx := make([]byte, 0, len(ch)+3)
+	pfx = append(pfx, byte(StorageChannelKey))
+	pfx = append(pfx, ch...)
+	return append(pfx, 0, byte(sk))
+}
+
+// splits a key made by makeKey back into its parts
+func parseKey(key []byte) (ch string, sk StorageKey, id string, ok bool) {
+	if len(key) < 2 {
+		return "", 0, "", false
+	}
+	if StorageKey(key[0]) != StorageChannelKey {
+		return "", StorageKey(key[0]), string(key[1:]), true
+	}
+	sep := bytes.IndexByte(key, 0)
+	if sep < 2 || sep+1 >= len(key) {
+		return "", 0, "", false
+	}
+	return string(key[1:sep]), StorageKey(key[sep+1]), string(key[sep+2:]), true
 }
 
 type Info struct {
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	badger "github.com/dgraph-io/badger/v2"
+)
+
+// Tags are indexed per channel like search terms, with a key for every tag
+// of every listed item:  <tag prefix> <tag> \0 <type> <id>. Hidden items
+// aren't listed, so aren't indexed either
+
+const maxTags = 20
+
+var validTag = regexp.MustCompile(`^[a-z0-9][a-z0-9_.:/+-]{0,63}$`)
+
+// TagCount is a tag, and how many items of each type carry it
+type TagCount struct {
+	Tag  string `json:"tag"`
+	Text int    `json:"text"`
+	Link int    `json:"link"`
+	File int    `json:"file"`
+}
+
+// tags from form values or query parameters, as repeated "tag" values, or
+// comma separated "tags"
+func tagsByValues(r map[string][]string) []string {
+	tags := append([]string{}, r["tag"]...)
+	for _, v := range r["tags"] {
+		tags = append(tags, strings.Split(v, ",")...)
+	}
+	return tags
+}
+
+// lowercases and dedupes an item's tags, rejecting invalid ones
+func setTags(c *CommonFields) error {
+	tags := make([]string, 0, len(c.Tags))
+	seen := map[string]bool{}
+	for _, t := range c.Tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		if !validTag.MatchString(t) {
+			return invalidInput("tag %q must be up to 64 letters, numbers or _.:/+- and start with a letter or number", t)
+		}
+		seen[t] = true
+		tags = append(tags, t)
+	}
+	if len(tags) > maxTags {
+		return invalidInput("at most %d tags are allowed", maxTags)
+	}
+	if len(tags) == 0 {
+		tags = nil
+	}
+	c.Tags = tags
+	return nil
+}
+
+func tagPrefix(ch string) []byte {
+	return typePrefix(ch, StorageTagKey)
+}
+
+func tagKey(ch string, tag string, sk StorageKey, id string) []byte {
+	key := append(tagPrefix(ch), tag...)
+	key = append(key, 0, byte(sk))
+	return append(key, id...)
+}
+
+// the tag index entries for an item
+func tagEntries(ch string, sk StorageKey, id string, rec record, u UMField, expiresAt uint64) []*badger.Entry {
+	c := rec.common()
+	if u.Has(Hidden) {
+		return nil
+	}
+	entries := make([]*badger.Entry, 0, len(c.Tags))
+	for _, t := range c.Tags {
+		entry := badger.NewEntry(tagKey(ch, t, sk, id), nil)
+		entry.ExpiresAt = expiresAt
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// the IDs of items of a type carrying a tag
+func taggedIDs(db *badger.DB, ch string, sk StorageKey, tag string) ([]string, error) {
+	ids := make([]string, 0, 20)
+	pfx := append(append(tagPrefix(ch), tag...), 0, byte(sk))
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = pfx
+	err := db.View(func(tx *badger.Txn) error {
+		it := tx.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(pfx); it.ValidForPrefix(pfx); it.Next() {
+			ids = append(ids, string(it.Item().Key()[len(pfx):]))
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// lists items of a type carrying all of the given tags, as getAllForType does
+func getAllTagged(db *badger.DB, ch string, sk StorageKey, tags []string) ([][]byte, error) {
+	for i, t := range tags {
+		tags[i] = strings.ToLower(t)
+	}
+	ids, err := taggedIDs(db, ch, sk, tags[0])
+	if err != nil {
+		return nil, err
+	}
+
+	total := make([][]byte, 0, len(ids))
+	err = db.View(func(tx *badger.Txn) error {
+		for _, id := range ids {
+			item, err := tx.Get(makeKey(ch, sk, id))
+			if err == badger.ErrKeyNotFound {
+				continue // expired between the two reads
+			}
+			if err != nil {
+				return err
+			}
+
+			rec, ok := newRecord(sk)
+			if !ok {
+				continue
+			}
+			if err := item.Value(func(v []byte) error {
+				return jsCfg.Unmarshal(v, rec)
+			}); err != nil {
+				return err
+			}
+			if !hasTags(rec.common().Tags, tags) {
+				continue
+			}
+
+			buf, ok, err := listValue(sk, item)
+			if err != nil {
+				return err
+			}
+			if ok {
+				total = append(total, buf)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return total, nil
+}
+
+func hasTags(have []string, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			if h == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// counts the items carrying each tag in a channel, in tag order
+func listTags(db *badger.DB, ch string) ([]TagCount, error) {
+	total := make([]TagCount, 0, 20)
+
+	pfx := tagPrefix(ch)
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = pfx
+	err := db.View(func(tx *badger.Txn) error {
+		it := tx.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(pfx); it.ValidForPrefix(pfx); it.Next() {
+			key := it.Item().KeyCopy(nil)
+			sep := bytes.IndexByte(key[len(pfx):], 0)
+			if sep < 0 || len(key) < len(pfx)+sep+3 {
+				continue
+			}
+			tag := string(key[len(pfx) : len(pfx)+sep])
+			sk := StorageKey(key[len(pfx)+sep+1])
+			id := string(key[len(pfx)+sep+2:])
+
+			if _, err := tx.Get(makeKey(ch, sk, id)); err == badger.ErrKeyNotFound {
+				continue // expired, as its entries are about to
+			} else if err != nil {
+				return err
+			}
+
+			if len(total) == 0 || total[len(total)-1].Tag != tag {
+				total = append(total, TagCount{Tag: tag})
+			}
+			c := &total[len(total)-1]
+			switch sk {
+			case StorageTextKey:
+				c.Text++
+			case StorageLinkKey:
+				c.Link++
+			case StorageFileGroupKey:
+				c.File++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return total, nil
+}
@@ -0,0 +1,291 @@
+package server
+
+import (
+	"errors"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// TrashItem is a deleted item, kept around until it is restored or purged
+type TrashItem struct {
+	Type    string              `json:"type"`
+	ID      string              `json:"id"`
+	Deleted int64               `json:"deleted"`           // timestamp of deletion
+	Purge   int64               `json:"purge,omitempty"`   // timestamp the trash entry goes away. Not stored
+	Meta    UMField             `json:"meta,omitempty"`    // original UserMeta flags
+	Expires int64               `json:"expires,omitempty"` // original expiration timestamp
+	Data    jsoniter.RawMessage `json:"data"`
+}
+
+// ErrRestoreConflict is returned when an item can't be restored because its
+// ID has since been taken
+var ErrRestoreConflict = errors.New("an item with this ID already exists")
+
+// trash entries are keyed by the original key, under the trash prefix
+func trashKey(ch string, sk StorageKey, id string) []byte {
+	return append([]byte{byte(StorageTrashKey)}, makeKey(ch, sk, id)...)
+}
+
+// there's no reason to keep trash around past when the item would have expired anyway
+func trashExpiry(expiresAt uint64, retention time.Duration) uint64 {
+	exp := uint64(time.Now().Add(retention).Unix())
+	if expiresAt > 0 && expiresAt < exp {
+		return expiresAt
+	}
+	return exp
+}
+
+// moves an item into the trash, out of the indexes. File groups bring their
+// file contents along
+func trashRecord(db *badger.DB, ch string, sk StorageKey, id string, retention time.Duration) error {
+	var expires uint64
+	var files []File
+
+	err := db.Update(func(tx *badger.Txn) error {
+		key := makeKey(ch, sk, id)
+		item, err := tx.Get(key)
+		if err != nil {
+			return err
+		}
+		data, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		if sk == StorageFileGroupKey {
+			var fg FileGroup
+			if err := jsCfg.Unmarshal(data, &fg); err != nil {
+				return err
+			}
+			files = fg.Files
+		}
+
+		buf, err := jsCfg.Marshal(TrashItem{
+			Type:    typeNames[sk],
+			ID:      id,
+			Deleted: time.Now().Unix(),
+			Meta:    UMField(item.UserMeta()),
+			Expires: int64(item.ExpiresAt()),
+			Data:    data,
+		})
+		if err != nil {
+			return err
+		}
+
+		expires = trashExpiry(item.ExpiresAt(), retention)
+		entry := badger.NewEntry(trashKey(ch, sk, id), buf)
+		entry.ExpiresAt = expires
+		if err := tx.SetEntry(entry); err != nil {
+			return err
+		}
+		if err := moveAux(tx, key, trashKey(ch, sk, id), expires); err != nil {
+			return err
+		}
+		if err := unindexTx(tx, ch, sk, id, item); err != nil {
+			return err
+		}
+		return tx.Delete(key)
+	})
+	if err != nil {
+		return err
+	}
+
+	// file contents may be large. Move them one transaction at a time
+	for _, f := range files {
+		err := moveRecord(db, makeKey("", StorageFileKey, f.ID), trashKey("", StorageFileKey, f.ID), expires)
+		if err != nil && err != badger.ErrKeyNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// puts a trashed item back where it was, with its original flags and
+// expiration, and back in the indexes
+func restoreRecord(db *badger.DB, ch string, sk StorageKey, id string) (TrashItem, error) {
+	var t TrashItem
+	key := makeKey(ch, sk, id)
+
+	err := db.Update(func(tx *badger.Txn) error {
+		if _, err := tx.Get(key); err == nil {
+			return ErrRestoreConflict
+		}
+		item, err := tx.Get(trashKey(ch, sk, id))
+		if err != nil {
+			return err
+		}
+		if err := item.Value(func(v []byte) error {
+			return jsCfg.Unmarshal(v, &t)
+		}); err != nil {
+			return err
+		}
+
+		entry := badger.NewEntry(key, t.Data).WithMeta(byte(t.Meta))
+		entry.ExpiresAt = uint64(t.Expires)
+		if err := tx.SetEntry(entry); err != nil {
+			return err
+		}
+		if err := moveAux(tx, trashKey(ch, sk, id), key, uint64(t.Expires)); err != nil {
+			return err
+		}
+		if err := indexTx(tx, ch, sk, id, t.Data, t.Meta, uint64(t.Expires)); err != nil {
+			return err
+		}
+		return tx.Delete(trashKey(ch, sk, id))
+	})
+	if err != nil {
+		return t, err
+	}
+
+	// file contents only follow once the group is back, so a conflict leaves
+	// them in the trash with it
+	if sk == StorageFileGroupKey {
+		var fg FileGroup
+		if err := jsCfg.Unmarshal(t.Data, &fg); err != nil {
+			return t, err
+		}
+		for _, f := range fg.Files {
+			err := moveRecord(db, trashKey("", StorageFileKey, f.ID), makeKey("", StorageFileKey, f.ID), uint64(t.Expires))
+			if err != nil && err != badger.ErrKeyNotFound {
+				return t, err
+			}
+		}
+	}
+	return t, nil
+
+}
+
+// permanently removes an item from the trash
+func purgeRecord(db *badger.DB, ch string, sk StorageKey, id string) error {
+	var t TrashItem
+	err := db.View(func(tx *badger.Txn) error {
+		item, err := tx.Get(trashKey(ch, sk, id))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			return jsCfg.Unmarshal(v, &t)
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	if sk == StorageFileGroupKey {
+		var fg FileGroup
+		if err := jsCfg.Unmarshal(t.Data, &fg); err != nil {
+			return err
+		}
+		for _, f := range fg.Files {
+			if err := deleteKey(db, trashKey("", StorageFileKey, f.ID)); err != nil {
+				return err
+			}
+		}
+	}
+	return db.Update(func(tx *badger.Txn) error {
+		if err := deleteAux(tx, trashKey(ch, sk, id)); err != nil {
+			return err
+		}
+		return tx.Delete(trashKey(ch, sk, id))
+	})
+}
+
+// permanently removes everything in a channel's trash
+func emptyTrash(db *badger.DB, ch string) error {
+	var ids []string
+	var types []StorageKey
+
+	err := db.View(func(tx *badger.Txn) error {
+		for _, sk := range []StorageKey{StorageTextKey, StorageLinkKey, StorageFileGroupKey} {
+			pfx := trashKey(ch, sk, "")
+			opts := badger.DefaultIteratorOptions
+			opts.PrefetchValues = false
+			opts.Prefix = pfx
+			it := tx.NewIterator(opts)
+			for it.Seek(pfx); it.ValidForPrefix(pfx); it.Next() {
+				ids = append(ids, string(it.Item().Key()[len(pfx):]))
+				types = append(types, sk)
+			}
+			it.Close()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// one at a time, so file groups take their contents along
+	for i := range ids {
+		if err := purgeRecord(db, ch, types[i], ids[i]); err != nil && err != badger.ErrKeyNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+func listTrash(db *badger.DB, ch string) ([]TrashItem, error) {
+	total := make([]TrashItem, 0, 20)
+
+	err := db.View(func(tx *badger.Txn) error {
+		for _, sk := range []StorageKey{StorageTextKey, StorageLinkKey, StorageFileGroupKey} {
+			pfx := trashKey(ch, sk, "")
+			opts := badger.DefaultIteratorOptions
+			opts.Prefix = pfx
+			it := tx.NewIterator(opts)
+			for it.Seek(pfx); it.ValidForPrefix(pfx); it.Next() {
+				var t TrashItem
+				if err := it.Item().Value(func(v []byte) error {
+					return jsCfg.Unmarshal(v, &t)
+				}); err != nil {
+					it.Close()
+					return err
+				}
+				if t.Meta.Has(Hidden) {
+					continue
+				}
+				if t.Meta.Has(BurnAfterRead) || t.Meta.Has(Locked) {
+					censored, err := censorContents(sk, t.Data)
+					if err != nil {
+						it.Close()
+						return err
+					}
+					t.Data = censored
+				}
+				t.Purge = int64(it.Item().ExpiresAt())
+				total = append(total, t)
+			}
+			it.Close()
+		}
+		return nil
+	})
+
+	return total, err
+}
+
+// moves a value to a new key, keeping its UserMeta, and setting a new expiration
+func moveRecord(db *badger.DB, from []byte, to []byte, expiresAt uint64) error {
+	return db.Update(func(tx *badger.Txn) error {
+		item, err := tx.Get(from)
+		if err != nil {
+			return err
+		}
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		entry := badger.NewEntry(to, val).WithMeta(item.UserMeta())
+		entry.ExpiresAt = expiresAt
+		if err := tx.SetEntry(entry); err != nil {
+			return err
+		}
+		return tx.Delete(from)
+	})
+}
+
+func deleteKey(db *badger.DB, key []byte) error {
+	return db.Update(func(tx *badger.Txn) error {
+		return tx.Delete(key)
+	})
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// The UI is built with this as its base path, and it is replaced by the one
+// the server is mounted under as the assets are served, so the same build
+// works under any prefix
+const basePlaceholder = "/__wapb_base__/"
+
+// ParseBaseURL reads the public URL the server is reached at. The scheme and
+// host may be left out, to take them from each request, leaving only the
+// path the server is mounted under, like /wapb/
+func ParseBaseURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme == "") != (u.Host == "") || (u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("base URL %q should be like https://example.com/path, or only a path", s)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("base URL %q may not have a query or fragment", s)
+	}
+	u.Path = "/" + strings.Trim(u.Path, "/")
+	u.RawPath = ""
+	return u, nil
+}
+
+// ParseTrustedProxies reads the addresses of proxies allowed to set
+// X-Forwarded-* headers, as IPs or CIDR ranges. "unix" trusts connections
+// over unix sockets
+func ParseTrustedProxies(defs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(defs))
+	for _, def := range defs {
+		if def == "unix" {
+			nets = append(nets, nil)
+			continue
+		}
+		if !strings.Contains(def, "/") {
+			ip := net.ParseIP(def)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted proxy %q is not an IP or CIDR range", def)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(def)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q is not an IP or CIDR range", def)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// the path the server is mounted under, without a trailing slash. Empty at the root
+func (s *Server) prefix() string {
+	if s.BaseURL == nil {
+		return ""
+	}
+	return strings.TrimSuffix(s.BaseURL.Path, "/")
+}
+
+// URL builds the public URL of a path on this server, like /text/abc123. It
+// comes from the base URL when set, or else the request, as forwarded by a
+// trusted proxy
+func (s *Server) URL(r *http.Request, path string) string {
+	return s.origin(r) + s.prefix() + path
+}
+
+// the scheme://host part of public URLs
+func (s *Server) origin(r *http.Request) string {
+	if s.BaseURL != nil && s.BaseURL.Host != "" {
+		return s.BaseURL.Scheme + "://" + s.BaseURL.Host
+	}
+	if f, ok := r.Context().Value(forwardedCtxKey).(forwarded); ok {
+		return f.proto + "://" + f.host
+	}
+	return scheme(r) + "://" + r.Host
+}
+
+// the scheme a request came in on, for building URLs
+func scheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+type forwarded struct {
+	proto string
+	host  string
+}
+
+// whether a request came straight from a trusted proxy
+func (s *Server) trustedPeer(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil || r.RemoteAddr == "@" {
+		return s.trustedIP(nil) // unix socket
+	}
+	return s.trustedIP(net.ParseIP(host))
+}
+
+// whether an address is a trusted proxy. nil is a unix socket connection
+func (s *Server) trustedIP(ip net.IP) bool {
+	for _, n := range s.TrustedProxies {
+		if (n == nil && ip == nil) || (n != nil && ip != nil && n.Contains(ip)) {
+			return true
+		}
+	}
+	return false
+}
+
+// takes the client's address, and the scheme and host it used, from the
+// headers set by trusted proxies. Requests from anywhere else, and all
+// requests when no proxies are trusted, are taken as they come
+func (s *Server) proxyHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(s.TrustedProxies) == 0 || !s.trustedPeer(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		f := forwarded{proto: scheme(r), host: r.Host}
+		if p := strings.ToLower(lastValue(r.Header.Values("X-Forwarded-Proto"))); p == "http" || p == "https" {
+			f.proto = p
+		}
+		if h := lastValue(r.Header.Values("X-Forwarded-Host")); h != "" {
+			f.host = h
+		}
+		if ip := s.clientIP(r); ip != "" {
+			r.RemoteAddr = ip
+		}
+		ctx := context.WithValue(r.Context(), forwardedCtxKey, f)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// the last of a header's comma separated values, as added by the proxy
+// nearest the server. Those before it came from further out, maybe from
+// the client itself
+func lastValue(values []string) string {
+	v := strings.Join(values, ",")
+	if i := strings.LastIndexByte(v, ','); i >= 0 {
+		v = v[i+1:]
+	}
+	return strings.TrimSpace(v)
+}
+
+// the client's address, as seen by the outermost trusted proxy. Each proxy
+// adds the address it was reached from to X-Forwarded-For, so the entries
+// are walked back from the end, past the trusted proxies. Without it, a
+// proxy's X-Real-IP is taken
+func (s *Server) clientIP(r *http.Request) string {
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		if i == 0 || !s.trustedIP(ip) {
+			return ip.String()
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// serves the router under the prefix from the base URL, if any
+func (s *Server) mount(h http.Handler) http.Handler {
+	pfx := s.prefix()
+	if pfx == "" {
+		return h
+	}
+	stripped := http.StripPrefix(pfx, h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == pfx:
+			http.Redirect(w, r, s.URL(r, "/"), http.StatusMovedPermanently)
+		case strings.HasPrefix(r.URL.Path, pfx+"/"):
+			stripped.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// serves the UI, with its placeholder base path swapped for the real one
+func (s *Server) serveAsset(w http.ResponseWriter, r *http.Request) {
+	// the body changes length, and must not be compressed to be rewritten
+	r.Header.Del("Range")
+	r.Header.Del("If-Range")
+	r.Header.Del("Accept-Encoding")
+
+	buf := &bufferedResponse{header: http.Header{}, status: http.StatusOK}
+	s.AssetHandler.ServeHTTP(buf, r)
+
+	body := buf.body.Bytes()
+	ct := buf.header.Get("Content-Type")
+	if strings.HasPrefix(ct, "text/") || strings.Contains(ct, "javascript") {
+		if r.Method == http.MethodHead {
+			buf.header.Del("Content-Length") // not known without the body
+		} else {
+			body = bytes.Replace(body, []byte(basePlaceholder), []byte(s.prefix()+"/"), -1)
+			buf.header.Set("Content-Length", strconv.Itoa(len(body)))
+		}
+	}
+	for k, v := range buf.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(buf.status)
+	w.Write(body)
+}
+
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+func (b *bufferedResponse) WriteHeader(status int)      { b.status = status }
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProxyHeaders(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	cases := []struct {
+		name    string
+		trusted []*net.IPNet
+		peer    string
+		headers map[string]string
+		addr    string // the client's address, as seen by the handler
+		origin  string // of generated URLs
+	}{
+		{
+			name:    "no trusted proxies",
+			peer:    "192.0.2.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "203.0.113.9", "X-Real-IP": "203.0.113.9", "X-Forwarded-Host": "evil.example"},
+			addr:    "192.0.2.1:1234", origin: "http://paste.example",
+		},
+		{
+			name:    "untrusted peer",
+			trusted: []*net.IPNet{proxies},
+			peer:    "192.0.2.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "203.0.113.9", "X-Forwarded-Proto": "https"},
+			addr:    "192.0.2.1:1234", origin: "http://paste.example",
+		},
+		{
+			name:    "trusted proxy",
+			trusted: []*net.IPNet{proxies},
+			peer:    "10.0.0.2:1234",
+			headers: map[string]string{"X-Forwarded-For": "203.0.113.9", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "public.example"},
+			addr:    "203.0.113.9", origin: "https://public.example",
+		},
+		{
+			name:    "spoofed by the client",
+			trusted: []*net.IPNet{proxies},
+			peer:    "10.0.0.2:1234",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.7, 203.0.113.9", "X-Forwarded-Proto": "http, https", "X-Forwarded-Host": "evil.example, public.example"},
+			addr:    "203.0.113.9", origin: "https://public.example",
+		},
+		{
+			name:    "chained proxies",
+			trusted: []*net.IPNet{proxies},
+			peer:    "10.0.0.2:1234",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.7, 203.0.113.9, 10.0.0.3"},
+			addr:    "203.0.113.9", origin: "http://paste.example",
+		},
+		{
+			name:    "unix socket",
+			trusted: []*net.IPNet{nil},
+			peer:    "@",
+			headers: map[string]string{"X-Real-IP": "203.0.113.9", "X-Forwarded-Host": "public.example"},
+			addr:    "203.0.113.9", origin: "http://public.example",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &Server{TrustedProxies: tc.trusted}
+			var addr, origin string
+			h := s.proxyHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				addr, origin = r.RemoteAddr, s.origin(r)
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "http://paste.example/", nil)
+			r.RemoteAddr = tc.peer
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			if addr != tc.addr || origin != tc.origin {
+				t.Errorf("got %s reaching %s, want %s reaching %s", addr, origin, tc.addr, tc.origin)
+			}
+		})
+	}
+}
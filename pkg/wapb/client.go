@@ -0,0 +1,224 @@
+package wapb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// item kinds, as they appear in URLs
+const (
+	KindText = "text"
+	KindLink = "link"
+	KindFile = "file"
+)
+
+var (
+	ErrNotFound         = errors.New("item not found")
+	ErrPasswordRequired = errors.New("item is password protected")
+	ErrWrongPassword    = errors.New("wrong password")
+)
+
+// StatusError is an unexpected response status from the server
+type StatusError struct {
+	Code int
+	Body string
+}
+
+func (e StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("server responded %d %s", e.Code, http.StatusText(e.Code))
+	}
+	return fmt.Sprintf("server responded %d %s: %s", e.Code, http.StatusText(e.Code), e.Body)
+}
+
+// Client talks to a wapb server
+type Client struct {
+	Server  string // base URL of the server, like http://localhost:7473
+	Channel string // named channel to use. Empty for the default one
+	Token   string // API token, for servers with auth enabled
+	HTTP    *http.Client
+}
+
+func New(server string) *Client {
+	return &Client{
+		Server: strings.TrimRight(server, "/"),
+		HTTP:   http.DefaultClient,
+	}
+}
+
+func (c *Client) channelPath() string {
+	if c.Channel == "" {
+		return ""
+	}
+	return "/c/" + c.Channel
+}
+
+// API URL for a path under the client's channel
+func (c *Client) api(path string) string {
+	return c.Server + "/api/v1" + c.channelPath() + path
+}
+
+// ItemURL is the web page URL of an item. Encrypted items carry their key in
+// the fragment
+func (c *Client) ItemURL(kind string, id string, key Key) string {
+	u := c.Server + c.channelPath() + "/" + kind + "/" + id
+	if key != nil {
+		u += "#" + key.String()
+	}
+	return u
+}
+
+// FileURL is where the contents of a single file in a group can be downloaded
+func (c *Client) FileURL(groupID string, fileID string) string {
+	return c.api("/" + KindFile + "/" + groupID + "/" + fileID)
+}
+
+// sends a request, decoding a JSON response into out when given
+func (c *Client) do(req *http.Request, out interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	res, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// sends a request, turning error statuses into errors. The caller closes the
+// body of successful responses
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 300 {
+		return res, nil
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, ErrNotFound
+	case res.StatusCode == http.StatusUnauthorized && res.Header.Get("WWW-Authenticate") == "":
+		return nil, ErrPasswordRequired
+	case res.StatusCode == http.StatusForbidden && req.Header.Get("X-Password") != "":
+		return nil, ErrWrongPassword
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+	return nil, StatusError{Code: res.StatusCode, Body: strings.TrimSpace(string(body))}
+}
+
+func (c *Client) create(kind string, item interface{}, out interface{}) error {
+	buf, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.api("/"+kind), bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req, out)
+}
+
+func (c *Client) get(kind string, id string, password string, out interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, c.api("/"+kind+"/"+id), nil)
+	if err != nil {
+		return err
+	}
+	if password != "" {
+		req.Header.Set("X-Password", password)
+	}
+	return c.do(req, out)
+}
+
+func (c *Client) list(kind string, out interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, c.api("/"+kind), nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, &struct {
+		Data interface{} `json:"data"`
+	}{out})
+}
+
+// CreateText stores a new text. The returned copy holds its ID and owner token
+func (c *Client) CreateText(t Text) (Text, error) {
+	var created Text
+	return created, c.create(KindText, t, &created)
+}
+
+// CreateLink stores a new link. The returned copy holds its ID and owner token
+func (c *Client) CreateLink(l Redirect) (Redirect, error) {
+	var created Redirect
+	return created, c.create(KindLink, l, &created)
+}
+
+// GetText fetches a text. The password is only needed for protected items
+func (c *Client) GetText(id string, password string) (Text, error) {
+	var t Text
+	return t, c.get(KindText, id, password, &t)
+}
+
+// GetLink fetches a link. The password is only needed for protected items
+func (c *Client) GetLink(id string, password string) (Redirect, error) {
+	var l Redirect
+	return l, c.get(KindLink, id, password, &l)
+}
+
+// GetFile fetches the listing of a file group. The password is only needed
+// for protected items
+func (c *Client) GetFile(id string, password string) (File, error) {
+	var f File
+	return f, c.get(KindFile, id, password, &f)
+}
+
+// ListTexts lists the texts in the channel. Hidden ones are left out, and
+// protected or burn after reading ones come without their contents
+func (c *Client) ListTexts() ([]Text, error) {
+	var texts []Text
+	return texts, c.list(KindText, &texts)
+}
+
+// ListLinks lists the links in the channel, as ListTexts does
+func (c *Client) ListLinks() ([]Redirect, error) {
+	var links []Redirect
+	return links, c.list(KindLink, &links)
+}
+
+// ListFiles lists the file groups in the channel, as ListTexts does
+func (c *Client) ListFiles() ([]File, error) {
+	var files []File
+	return files, c.list(KindFile, &files)
+}
+
+// Download fetches the contents of a single file in a group. The caller
+// closes them
+func (c *Client) Download(groupID string, fileID string, password string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, c.FileURL(groupID, fileID), nil)
+	if err != nil {
+		return nil, err
+	}
+	if password != "" {
+		req.Header.Set("X-Password", password)
+	}
+	res, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
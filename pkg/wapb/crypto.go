@@ -0,0 +1,103 @@
+package wapb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// KeySize is the size of the AES-256 keys used for encrypted items
+const KeySize = 32
+
+// ErrDecrypt is returned when contents can't be decrypted with a key, whether
+// the key is wrong or the contents were tampered with
+var ErrDecrypt = errors.New("unable to decrypt contents. The key may be wrong")
+
+// Key is an encryption key for client-side encrypted items. It is carried in
+// the fragment of an item's URL, which browsers never send to the server
+type Key []byte
+
+// NewKey generates a random key
+func NewKey() (Key, error) {
+	k := make(Key, KeySize)
+	if _, err := rand.Read(k); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// ParseKey reads a key as found in a URL fragment
+func ParseKey(s string) (Key, error) {
+	k, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+	if len(k) != KeySize {
+		return nil, fmt.Errorf("invalid key: expected %d bytes, got %d", KeySize, len(k))
+	}
+	return Key(k), nil
+}
+
+// String encodes a key for use in a URL fragment
+func (k Key) String() string { return base64.RawURLEncoding.EncodeToString(k) }
+
+func (k Key) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts with AES-GCM. The output is the random nonce followed by
+// the ciphertext
+func (k Key) Seal(plaintext []byte) ([]byte, error) {
+	gcm, err := k.aead()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open decrypts the output of Seal
+func (k Key) Open(sealed []byte) ([]byte, error) {
+	gcm, err := k.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// EncryptString encrypts text fields, like a text body or link URL, which
+// are stored as base64 of the sealed bytes
+func (k Key) EncryptString(plaintext string) (string, error) {
+	sealed, err := k.Seal([]byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptString reverses EncryptString
+func (k Key) DecryptString(ciphertext string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	plaintext, err := k.Open(sealed)
+	return string(plaintext), err
+}
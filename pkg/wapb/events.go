@@ -0,0 +1,93 @@
+package wapb
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// events, as named by the server
+const (
+	EventCreated  = "created"  // a new item
+	EventUploaded = "uploaded" // files added to a group
+)
+
+// Event is a change to an item in the channel. Item holds it as listed, so
+// protected and burn after reading items come without their contents
+type Event struct {
+	Name string          `json:"-"`
+	Kind string          `json:"type"`
+	Item json.RawMessage `json:"item"`
+}
+
+// EventStream is an open connection to a channel's events
+type EventStream struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+}
+
+// Events connects to the channel's event stream. Servers without one give
+// ErrNotFound. Cancelling the context ends it
+func (c *Client) Events(ctx context.Context) (*EventStream, error) {
+	req, err := http.NewRequest(http.MethodGet, c.api("/events"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "text/event-stream")
+	res, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(res.Body)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	return &EventStream{body: res.Body, scanner: scanner}, nil
+}
+
+// Next waits for the next event. io.EOF when the server ended the stream
+func (s *EventStream) Next() (Event, error) {
+	// as in the server-sent events spec, fields build up an event, which a
+	// blank line ends
+	var name, data string
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		if line == "" {
+			if data == "" {
+				name = ""
+				continue
+			}
+			var e Event
+			if err := json.Unmarshal([]byte(data), &e); err != nil {
+				return e, err
+			}
+			e.Name = name
+			return e, nil
+		}
+
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "event":
+			name = value
+		case "data":
+			if data != "" {
+				data += "\n"
+			}
+			data += value
+		}
+	}
+	if err := s.scanner.Err(); err != nil {
+		return Event{}, err
+	}
+	return Event{}, io.EOF
+}
+
+func (s *EventStream) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,69 @@
+package wapb
+
+import (
+	"io"
+	"mime/multipart"
+	"net/http"
+)
+
+// Upload is a file to add to a group
+type Upload struct {
+	Name string    // stored as its filename. May hold relative directories, separated by /
+	Body io.Reader // contents, read as they are sent
+}
+
+// CreateFile makes a new, empty file group. The returned copy holds its ID,
+// and the owner token needed to upload into it
+func (c *Client) CreateFile(f File) (File, error) {
+	var created File
+	return created, c.create(KindFile, f, &created)
+}
+
+// Upload adds files to a group, streaming them in a single request. The owner
+// token comes from CreateFile. progress, when given, is called as contents
+// are sent, with the file and the number of bytes just sent of it
+func (c *Client) Upload(groupID string, owner string, files []Upload, progress func(name string, n int)) error {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeUploads(mw, files, progress))
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, c.api("/"+KindFile+"/"+groupID), pr)
+	if err != nil {
+		pr.Close()
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("X-Owner-Token", owner)
+	err = c.do(req, nil)
+	pr.CloseWithError(err) // stops the writer, if the server gave up early
+	return err
+}
+
+func writeUploads(mw *multipart.Writer, files []Upload, progress func(name string, n int)) error {
+	for _, f := range files {
+		part, err := mw.CreateFormFile("file", f.Name)
+		if err != nil {
+			return err
+		}
+		var w io.Writer = part
+		if progress != nil {
+			w = io.MultiWriter(part, progressWriter{f.Name, progress})
+		}
+		if _, err := io.Copy(w, f.Body); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+type progressWriter struct {
+	name     string
+	progress func(name string, n int)
+}
+
+func (p progressWriter) Write(b []byte) (int, error) {
+	p.progress(p.name, len(b))
+	return len(b), nil
+}
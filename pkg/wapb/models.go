@@ -1,17 +1,47 @@
 package wapb
 
+// StoredCommon holds the fields every stored item has
 type StoredCommon struct {
-	ID string
+	ID        string   `json:"id,omitempty"`
+	Created   int64    `json:"created,omitempty"` // timestamp of creation
+	TTL       int64    `json:"ttl,omitempty"`     // seconds until the item expires. 0 is never
+	Sliding   bool     `json:"sliding,omitempty"` // each read renews the TTL
+	Burn      bool     `json:"burn,omitempty"`    // deleted after the first read
+	Hidden    bool     `json:"hidden,omitempty"`  // left out of listings
+	Password  string   `json:"password,omitempty"`
+	Locked    bool     `json:"locked,omitempty"`    // password protected
+	Encrypted bool     `json:"encrypted,omitempty"` // contents are encrypted with a key the server never sees
+	MaxViews  int64    `json:"maxViews,omitempty"`  // deleted after this many reads. 0 is unlimited
+	Views     int64    `json:"views,omitempty"`     // times the item was read
+	Accessed  int64    `json:"accessed,omitempty"`  // timestamp of the last read
+	Expires   int64    `json:"expires,omitempty"`   // timestamp the item goes away. 0 is never
+	Remaining int64    `json:"remaining,omitempty"` // seconds until it expires, at the time of the response
+	Owner     string   `json:"owner,omitempty"`     // owner token. Only returned on creation
+	Tags      []string `json:"tags,omitempty"`
 }
 
+// File is a group of uploaded files
 type File struct {
 	StoredCommon
+	Files []FileInfo `json:"files,omitempty"`
 }
 
+// FileInfo describes a single file in a group
+type FileInfo struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	FileName string `json:"filename"`
+	Mime     string `json:"mime"`
+	Size     int64  `json:"size"`
+}
+
+// Redirect is a stored link
 type Redirect struct {
 	StoredCommon
+	URL string `json:"url"`
 }
 
 type Text struct {
 	StoredCommon
+	Text string `json:"text"`
 }
@@ -0,0 +1,79 @@
+package wapb
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var (
+	plainSeconds = regexp.MustCompile(`^[0-9]+$`)
+	ttlPart      = regexp.MustCompile(`^([0-9]+)([smhdw])`)
+)
+
+var ttlUnits = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+// ParseTTL reads a time-to-live as either plain seconds, or a duration like
+// 10m, 2h, 7d or 1d12h. Units are s, m, h, d and w
+func ParseTTL(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty ttl")
+	}
+	if plainSeconds.MatchString(s) {
+		secs, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid ttl %q: %w", s, err)
+		}
+		return addTTL(s, 0, secs, time.Second)
+	}
+
+	var total time.Duration
+	rest := s
+	for rest != "" {
+		m := ttlPart.FindStringSubmatch(rest)
+		if m == nil {
+			return 0, fmt.Errorf("invalid ttl %q. Use seconds, or a duration like 10m, 2h or 7d", s)
+		}
+		n, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid ttl %q: %w", s, err)
+		}
+		if total, err = addTTL(s, total, n, ttlUnits[m[2]]); err != nil {
+			return 0, err
+		}
+		rest = rest[len(m[0]):]
+	}
+	return total, nil
+}
+
+// adds n units to a ttl, refusing any too long to be held as a duration
+func addTTL(s string, total time.Duration, n int64, unit time.Duration) (time.Duration, error) {
+	if n > int64((math.MaxInt64-total)/unit) {
+		return 0, fmt.Errorf("invalid ttl %q: too long", s)
+	}
+	return total + time.Duration(n)*unit, nil
+}
+
+// ParseExpires reads an absolute expiration time, as a unix timestamp or RFC 3339
+func ParseExpires(s string) (time.Time, error) {
+	if plainSeconds.MatchString(s) {
+		secs, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid expiration %q: %w", s, err)
+		}
+		return time.Unix(secs, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiration %q. Use a unix timestamp, or a time like 2006-01-02T15:04:05Z", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,69 @@
+package wapb
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ItemRef points to a single item on a server, as read from its URL
+type ItemRef struct {
+	Server  string
+	Channel string
+	Kind    string
+	ID      string
+	Key     Key // only for encrypted items
+}
+
+// ParseItemURL reads an item's web page or API URL, such as
+// http://host/c/work/text/abc123#key. Servers mounted under a path, like
+// http://host/wapb/text/abc123, keep it as part of Server
+func ParseItemURL(raw string) (ItemRef, error) {
+	var ref ItemRef
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ref, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return ref, fmt.Errorf("%q is not a full URL", raw)
+	}
+
+	// read from the end, as anything before belongs to the mount path
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] == "" {
+		return ref, fmt.Errorf("%q does not point to an item", raw)
+	}
+	n := len(parts)
+	switch parts[n-2] {
+	case KindText, KindLink, KindFile:
+	default:
+		return ref, fmt.Errorf("unknown item type %q", parts[n-2])
+	}
+	ref.Kind, ref.ID = parts[n-2], parts[n-1]
+	parts = parts[:n-2]
+	if n = len(parts); n >= 2 && parts[n-2] == "c" {
+		ref.Channel = parts[n-1]
+		parts = parts[:n-2]
+	}
+	if n = len(parts); n >= 2 && parts[n-2] == "api" && parts[n-1] == "v1" {
+		parts = parts[:n-2]
+	}
+	ref.Server = u.Scheme + "://" + u.Host
+	if len(parts) > 0 {
+		ref.Server += "/" + strings.Join(parts, "/")
+	}
+
+	if u.Fragment != "" {
+		if ref.Key, err = ParseKey(u.Fragment); err != nil {
+			return ref, err
+		}
+	}
+	return ref, nil
+}
+
+// Client for the server and channel the item lives on
+func (ref ItemRef) Client() *Client {
+	c := New(ref.Server)
+	c.Channel = ref.Channel
+	return c
+}